@@ -0,0 +1,84 @@
+package dstrfn
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+// Opens a port for the server.
+// If laddr is empty, listens on an ephemeral port on all interfaces.
+// Returns the listener and the address at which slaves can reach it.
+func listen(laddr string) (net.Listener, string, error) {
+	if len(laddr) == 0 {
+		laddr = ":0"
+	}
+	l, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return nil, "", err
+	}
+	host, _, err := net.SplitHostPort(laddr)
+	if err != nil {
+		l.Close()
+		return nil, "", err
+	}
+	if ip := net.ParseIP(host); len(host) == 0 || ip != nil && ip.IsUnspecified() {
+		// Listening on all interfaces.
+		host = routableHost()
+	}
+	// The port may have been chosen by the system.
+	port := l.Addr().(*net.TCPAddr).Port
+	return l, net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// Returns a name or address of this machine which other machines can use.
+// Prefers the hostname if it resolves to a non-loopback address,
+// then the first non-loopback address of an interface.
+// Falls back to the loopback address.
+func routableHost() string {
+	if name, err := os.Hostname(); err == nil {
+		if addrs, err := net.LookupIP(name); err == nil {
+			for _, ip := range addrs {
+				if !ip.IsLoopback() {
+					return name
+				}
+			}
+		}
+	}
+	if ip := interfaceIP(); ip != nil {
+		return ip.String()
+	}
+	return "127.0.0.1"
+}
+
+// Returns the first non-loopback address of an interface which is up.
+// Prefers IPv4. Returns nil if there is none.
+func interfaceIP() net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var v6 net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ipnet.IP.To4() != nil {
+				return ipnet.IP
+			}
+			if v6 == nil {
+				v6 = ipnet.IP
+			}
+		}
+	}
+	return v6
+}
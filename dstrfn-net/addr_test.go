@@ -0,0 +1,57 @@
+package dstrfn
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListen_Ephemeral(t *testing.T) {
+	// Two listeners must not collide.
+	l1, addr1, err := listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l2, addr2, err := listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	if addr1 == addr2 {
+		t.Errorf("expect different addresses, got %s twice", addr1)
+	}
+
+	host, port, err := net.SplitHostPort(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(host) == 0 || port == "0" {
+		t.Errorf("expect host and port, got %s", addr1)
+	}
+	// The advertised address must reach the listener.
+	go func() {
+		if conn, err := l1.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListen_Host(t *testing.T) {
+	l, addr, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "127.0.0.1" {
+		t.Errorf("expect host 127.0.0.1, got %s", host)
+	}
+}
@@ -0,0 +1,157 @@
+package dstrfn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// Returns a random token which slaves must present to the master.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Compares tokens in constant time.
+func validToken(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+var (
+	certOnce sync.Once
+	cert     tls.Certificate
+	certHash string
+	certErr  error
+)
+
+// Returns a self-signed certificate for the master and its SHA-256 fingerprint.
+// The certificate is generated once per process.
+func masterCert() (tls.Certificate, string, error) {
+	certOnce.Do(func() {
+		cert, certHash, certErr = generateCert()
+	})
+	return cert, certHash, certErr
+}
+
+func generateCert() (tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "dstrfn"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(30 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	c := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return c, fingerprint(der), nil
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns a client configuration which accepts only the certificate
+// with the given fingerprint.
+// The certificate is self-signed, so it is pinned instead of verified.
+func pinnedConfig(hash string) (*tls.Config, error) {
+	want, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("certificate fingerprint: %v", err)
+	}
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 {
+				return errors.New("no certificate from master")
+			}
+			sum := sha256.Sum256(raw[0])
+			if !bytes.Equal(sum[:], want) {
+				return errors.New("certificate of master does not match fingerprint")
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Describes how a slave connects to the master.
+type endpoint struct {
+	Addr  string
+	Codec Codec
+	// Map to which the requests belong
+	// and token to present with every request.
+	Run   string
+	Token string
+	// Configuration for TLS, nil for plain TCP.
+	TLS *tls.Config
+}
+
+// The certificate fingerprint certHash is empty for plain TCP.
+func newEndpoint(addr, encoding, run, token, certHash string) (*endpoint, error) {
+	codec, err := lookupCodec(encoding)
+	if err != nil {
+		return nil, err
+	}
+	e := &endpoint{Addr: addr, Codec: codec, Run: run, Token: token}
+	if len(certHash) > 0 {
+		e.TLS, err = pinnedConfig(certHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Opens a connection to the master and sends the header and the type of the request.
+func (e *endpoint) request(typ string) (net.Conn, Encoder, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if e.TLS != nil {
+		conn, err = tls.Dial("tcp", e.Addr, e.TLS)
+	} else {
+		conn, err = net.Dial("tcp", e.Addr)
+	}
+	if err != nil {
+		return nil, nil, errors.New("connect to server: " + err.Error())
+	}
+	if err := writeHeader(conn, e.Run, e.Token); err != nil {
+		conn.Close()
+		return nil, nil, errors.New("send header: " + err.Error())
+	}
+	enc := e.Codec.NewEncoder(conn)
+	if err := enc.Encode(typ); err != nil {
+		conn.Close()
+		return nil, nil, errors.New("send request type: " + err.Error())
+	}
+	return conn, enc, nil
+}
@@ -0,0 +1,248 @@
+package dstrfn
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// Codec describes how messages between master and slaves are encoded,
+// including the inputs, parameters and outputs.
+type Codec interface {
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Encoder writes a sequence of values to a stream.
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+// Decoder reads a sequence of values from a stream.
+// The argument to Decode must be a pointer.
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+var codecs = make(map[string]Codec)
+
+func init() {
+	RegisterCodec("json", jsonCodec{})
+	RegisterCodec("gob", gobCodec{})
+	RegisterCodec("msgpack", msgpackCodec{})
+	RegisterCodec("binary", binaryCodec{})
+}
+
+// RegisterCodec makes a codec available by name.
+// Panics if the name is already used.
+func RegisterCodec(name string, codec Codec) {
+	if _, used := codecs[name]; used {
+		panic(fmt.Sprintf(`codec already registered: "%s"`, name))
+	}
+	codecs[name] = codec
+}
+
+// Encoding sets the codec of a task by name.
+// The default is "json".
+// The flag -name.encoding overrides this setting.
+func Encoding(name string) Option {
+	return func(st *subTask) {
+		st.Encoding = name
+	}
+}
+
+func lookupCodec(name string) (Codec, error) {
+	codec, there := codecs[name]
+	if !there {
+		return nil, fmt.Errorf(`codec not found: "%s"`, name)
+	}
+	return codec, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder { return json.NewEncoder(w) }
+func (jsonCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
+
+type gobCodec struct{}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder { return gob.NewEncoder(w) }
+func (gobCodec) NewDecoder(r io.Reader) Decoder { return gob.NewDecoder(r) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) NewEncoder(w io.Writer) Encoder { return msgpack.NewEncoder(w) }
+func (msgpackCodec) NewDecoder(r io.Reader) Decoder { return msgpack.NewDecoder(r) }
+
+// Encodes values in little-endian order using encoding/binary.
+// Strings and slices are prefixed by their length.
+// Values of type int and uint are encoded using 64 bits.
+// Supports fixed-size values, strings, and slices and pointers of these.
+// Maps and structs containing variable-size fields are not supported.
+type binaryCodec struct{}
+
+func (binaryCodec) NewEncoder(w io.Writer) Encoder { return &binaryEncoder{w} }
+func (binaryCodec) NewDecoder(r io.Reader) Decoder { return &binaryDecoder{r} }
+
+type binaryEncoder struct {
+	w io.Writer
+}
+
+func (e *binaryEncoder) Encode(v interface{}) error {
+	return e.encode(reflect.ValueOf(v))
+}
+
+func (e *binaryEncoder) encode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return errors.New("binary: cannot encode nil")
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return errors.New("binary: cannot encode nil")
+		}
+		return e.encode(v.Elem())
+	case reflect.String:
+		if err := e.writeLen(v.Len()); err != nil {
+			return err
+		}
+		_, err := io.WriteString(e.w, v.String())
+		return err
+	case reflect.Int:
+		return binary.Write(e.w, binary.LittleEndian, v.Int())
+	case reflect.Uint:
+		return binary.Write(e.w, binary.LittleEndian, v.Uint())
+	case reflect.Slice:
+		if err := e.writeLen(v.Len()); err != nil {
+			return err
+		}
+		if isFixedSize(v.Type().Elem()) {
+			return binary.Write(e.w, binary.LittleEndian, v.Interface())
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if !isFixedSize(v.Type()) {
+			return fmt.Errorf("binary: unsupported type: %v", v.Type())
+		}
+		return binary.Write(e.w, binary.LittleEndian, v.Interface())
+	}
+}
+
+// Maximum length of a string or slice in the binary encoding.
+// Lengths are read before the data,
+// so a corrupt or malicious length must not be used to allocate memory.
+const maxBinaryLen = 1 << 30
+
+func (e *binaryEncoder) writeLen(n int) error {
+	if n > maxBinaryLen {
+		return fmt.Errorf("binary: length %d exceeds maximum %d", n, maxBinaryLen)
+	}
+	return binary.Write(e.w, binary.LittleEndian, uint64(n))
+}
+
+type binaryDecoder struct {
+	r io.Reader
+}
+
+func (d *binaryDecoder) Decode(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return errors.New("binary: decode requires non-nil pointer")
+	}
+	return d.decode(val.Elem())
+}
+
+// The value v must be addressable.
+func (d *binaryDecoder) decode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		// Decode into the value which the interface refers to.
+		if v.IsNil() || v.Elem().Kind() != reflect.Ptr {
+			return fmt.Errorf("binary: cannot decode into %v", v.Type())
+		}
+		return d.decode(v.Elem())
+	case reflect.String:
+		n, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return err
+		}
+		v.SetString(string(b))
+		return nil
+	case reflect.Int:
+		var x int64
+		if err := binary.Read(d.r, binary.LittleEndian, &x); err != nil {
+			return err
+		}
+		v.SetInt(x)
+		return nil
+	case reflect.Uint:
+		var x uint64
+		if err := binary.Read(d.r, binary.LittleEndian, &x); err != nil {
+			return err
+		}
+		v.SetUint(x)
+		return nil
+	case reflect.Slice:
+		n, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		// Re-use existing elements, for example a list of arguments.
+		if v.Len() != n {
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		}
+		if isFixedSize(v.Type().Elem()) {
+			return binary.Read(d.r, binary.LittleEndian, v.Interface())
+		}
+		for i := 0; i < n; i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if !isFixedSize(v.Type()) {
+			return fmt.Errorf("binary: unsupported type: %v", v.Type())
+		}
+		return binary.Read(d.r, binary.LittleEndian, v.Addr().Interface())
+	}
+}
+
+func (d *binaryDecoder) readLen() (int, error) {
+	var n uint64
+	if err := binary.Read(d.r, binary.LittleEndian, &n); err != nil {
+		return 0, err
+	}
+	if n > maxBinaryLen {
+		return 0, fmt.Errorf("binary: length %d exceeds maximum %d", n, maxBinaryLen)
+	}
+	return int(n), nil
+}
+
+// Reports whether encoding/binary can encode the type directly.
+func isFixedSize(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		// The size of a slice is not part of its type.
+		return false
+	}
+	return binary.Size(reflect.Zero(t).Interface()) >= 0
+}
@@ -39,23 +39,28 @@ ExecIfSlave will never return if the program has been invoked in slave mode.
 	}
 
 This program is then invoked at the shell according to
-	$ ./example -dstrfn.addr=`hostname`:12345
+	$ ./example
 and the call to dstrfn.Map() will execute the command
-	$ qsub [opts] -- /path/to/example -dstrfn.addr=`hostname`:12345 -dstrfn.task=square
+	$ qsub [opts] -- /path/to/example -dstrfn.addr=host:port -dstrfn.task=square [...]
 
 Command line flags
 
 The above code defines a number of additional flags.
 	$ ./example -help
 	Usage of ./example:
-	  -dstrfn.addr="": Address of master on network.
+	  -dstrfn.addr="": Address of master on network. Empty to choose a port and detect the host.
 	  -dstrfn.task="": Task to execute as slave. Empty to execute as master.
 	  -square.chunk-len=1: Split into chunks of up to this many elements.
 	  -square.flags="": Additional flags
 	  -square.stderr=false: Keep stderr?
 	  -square.stdout=false: Keep stdout?
 
-The address must be in a format which can be used with net.Listen() and net.Dial() (for the master and slaves respectively).
+By default, the master listens on a port chosen by the system
+and gives the slaves the hostname of the machine, or the address of its first network interface if the hostname does not resolve.
+Several programs can then run on the same machine without choosing ports.
+The flag -dstrfn.addr sets the address explicitly.
+It must be in a format which can be used with net.Listen(), for example host:12345 or :0.
+If the host is omitted, it is detected as above.
 
 The -task.flags option provides a way to specify task-dependent flags to qsub.
 	$ ./example [...] -square.flags "-l mem=100m,walltime=0:15:00"
@@ -65,6 +70,60 @@ It provides a way to perform multiple maps per task.
 Note that only functions with concrete types can be used with chunking.
 Specifically, types X which can be decoded from JSON into an empty slice of type []X.
 
+Workers
+
+By default, a map submits one job per element.
+The flag -task.workers=k instead submits k jobs.
+Each job requests inputs from the master until there are none left.
+Fast workers take more elements, and the startup cost of a job is paid only k times.
+
+Leases
+
+Each input is given to a slave as a lease, which the slave renews by sending heartbeats.
+If a slave dies, its lease expires after -task.lease and the input is given to another slave.
+Without -task.workers, each job exits after one input,
+so an expired input is only given out again if a job has not yet started.
+Each input is leased at most -task.attempts times.
+Inputs which never produce an output are reported in a MapError.
+
+Errors
+
+If the function returns an error for some elements, the map continues with the others.
+The error of the map is then a MapError whose Tasks contains the error of each failed element,
+and the outputs of the other elements are assigned as usual.
+A panic in the function is recovered by the slave and reported in the same way,
+with the panic value and the stack trace of the slave.
+Each error is a *TaskError, which also records the host, the PBS job ID,
+the attempt, the time taken and the Go type of the original error.
+
+Concurrent maps
+
+The master starts one server the first time it performs a map, and the server lives as long as the process.
+Each map is given a run ID, which its slaves include in every request,
+so several maps can run at the same time from different goroutines.
+
+Security
+
+Each map generates a random token which is given to its slaves in their arguments.
+The master rejects any request which does not carry the token.
+The flag -dstrfn.tls encrypts the connections using a certificate which is generated when the program starts.
+The slaves are given the fingerprint of the certificate and accept no other.
+
+Encoding
+
+Messages between the master and the slaves are encoded using a codec.
+The default is JSON, which cannot represent NaN or infinity.
+Another codec can be selected when the task is registered:
+	dstrfn.Register("square", true, sqr, dstrfn.Encoding("msgpack"))
+The flag -task.encoding overrides this setting: json, gob, msgpack or binary.
+Further codecs can be added using dstrfn.RegisterCodec().
+
+Schedulers
+
+Jobs are submitted through a Scheduler.
+The default is PBS Pro, which is selected by -dstrfn.backend=pbs.
+Other schedulers can be added using dstrfn.RegisterScheduler() and selected by name.
+
 Additional parameters
 
 To call a function accepting one additional parameter which is constant for all x[i]:
@@ -75,9 +134,13 @@ To call a function accepting one additional parameter which is constant for all
 
 Reduce operations
 
-Reduce operations are performed as a series of maps from a list of Pairs of X to a list of Xs.
+Reduce operations are performed as a series of maps from a list of groups []X to a list of Xs.
 Tasks for reducing are registered in the same way as those for mapping, however they must be called using dstrfn.Reduce() not dstrfn.Map().
-Note that reduce operations defined with ReduceFunc() are incompatible with chunking because Pair has members of type interface{}.
+
+The flag -task.arity=k sets the number of elements in each group (default 2).
+Each job combines its k elements locally, so a reduce of n elements requires log_k(n) submissions.
+The flag is only defined for tasks created by dstrfn.ReduceFunc().
+Each job of any other task is given a dstrfn.Pair of elements and k is 2.
 
 To do a reduce operation:
 	dstrfn.Register("add", false, dstrfn.ReduceFunc(
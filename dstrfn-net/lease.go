@@ -0,0 +1,147 @@
+package dstrfn
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Gives out the indices of a map as leases with a deadline.
+// A lease is renewed by each heartbeat of the slave which holds it.
+// Indices whose lease expires are put back on the queue
+// until they have been leased the maximum number of times.
+type leaseQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	ttl  time.Duration
+	// Maximum number of times each index is leased.
+	attempts int
+
+	queue    []int
+	deadline map[int]time.Time
+	count    map[int]int
+	// Indices which produced an output or error, or were lost.
+	resolved map[int]bool
+	n        int
+	closed   bool
+	// Closed once every index has been resolved.
+	done chan struct{}
+}
+
+func newLeaseQueue(n int, ttl time.Duration, attempts int) *leaseQueue {
+	q := &leaseQueue{
+		ttl:      ttl,
+		attempts: max(attempts, 1),
+		queue:    make([]int, n),
+		deadline: make(map[int]time.Time),
+		count:    make(map[int]int),
+		resolved: make(map[int]bool),
+		n:        n,
+		done:     make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	for i := range q.queue {
+		q.queue[i] = i
+	}
+	if n == 0 {
+		close(q.done)
+	}
+	return q
+}
+
+// Leases the next index.
+// If wait is true, blocks while the queue is empty and some leases are outstanding,
+// since an expired lease may put an index back on the queue.
+// Returns false if there is no more work.
+func (q *leaseQueue) next(wait bool) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for wait && len(q.queue) == 0 && len(q.resolved) < q.n && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 || q.closed {
+		return -1, false
+	}
+	i := q.queue[0]
+	q.queue = q.queue[1:]
+	q.deadline[i] = time.Now().Add(q.ttl)
+	q.count[i]++
+	return i, true
+}
+
+// Extends the lease of index i.
+func (q *leaseQueue) renew(i int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, leased := q.deadline[i]; leased {
+		q.deadline[i] = time.Now().Add(q.ttl)
+	}
+}
+
+// Returns the number of times index i has been leased.
+func (q *leaseQueue) leased(i int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count[i]
+}
+
+// Resolves index i and calls f while holding the lock.
+// Returns false without calling f if the index was already resolved,
+// for example by a slave whose lease had expired.
+func (q *leaseQueue) complete(i int, f func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if i < 0 || i >= q.n || q.resolved[i] {
+		return false
+	}
+	f()
+	q.resolve(i)
+	// The index may have been put back on the queue.
+	for j, k := range q.queue {
+		if k == i {
+			q.queue = append(q.queue[:j], q.queue[j+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Puts the indices whose lease has expired back on the queue.
+// Returns the errors of the indices which have been leased too many times.
+func (q *leaseQueue) expire(now time.Time) map[int]error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	lost := make(map[int]error)
+	for i, t := range q.deadline {
+		if now.Before(t) {
+			continue
+		}
+		delete(q.deadline, i)
+		if q.count[i] < q.attempts {
+			q.queue = append(q.queue, i)
+			continue
+		}
+		lost[i] = fmt.Errorf("lease expired %d times", q.count[i])
+		q.resolve(i)
+	}
+	q.cond.Broadcast()
+	return lost
+}
+
+// Must be called with the lock held.
+func (q *leaseQueue) resolve(i int) {
+	delete(q.deadline, i)
+	q.resolved[i] = true
+	if len(q.resolved) == q.n {
+		close(q.done)
+	}
+	q.cond.Broadcast()
+}
+
+// Wakes every call to next without resolving the remaining indices.
+func (q *leaseQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
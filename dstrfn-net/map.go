@@ -1,11 +1,11 @@
 package dstrfn
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
-	"strings"
 )
 
 // Default place to route stdout and stderr of qsub when invoked.
@@ -27,6 +27,13 @@ func Map(f string, y, x, p interface{}) error {
 }
 
 func MapWriteTo(f string, y, x, p interface{}, cmdout, cmderr io.Writer) error {
+	return MapContext(context.Background(), f, y, x, p, cmdout, cmderr)
+}
+
+// MapContext is like MapWriteTo but deletes the jobs if the context is done first.
+// The error is then a MapError whose Master is ctx.Err()
+// and whose Tasks include every element which did not finish.
+func MapContext(ctx context.Context, f string, y, x, p interface{}, cmdout, cmderr io.Writer) error {
 	task, there := tasks[f]
 	if !there {
 		return fmt.Errorf(`task not found: "%s"`, f)
@@ -36,10 +43,13 @@ func MapWriteTo(f string, y, x, p interface{}, cmdout, cmderr io.Writer) error {
 	// This changes the type of y from *[]Y to []Y.
 	y = ensureLenAndDeref(y, n)
 
-	var u, v interface{}
+	var (
+		u, v interface{}
+		inds [][]int
+	)
 	if task.Chunk {
 		m := max(task.ChunkLen, 1)
-		u = split(x, 1, m)
+		u, inds = split(x, 1, m)
 		l := reflect.ValueOf(u).Len()
 		v = reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(y)), l, l).Interface()
 	} else {
@@ -47,10 +57,29 @@ func MapWriteTo(f string, y, x, p interface{}, cmdout, cmderr io.Writer) error {
 		v = y
 	}
 
-	userargs := strings.Split(task.Flags, " ")
-	err := master(task.Task, f, v, u, p, userargs, cmdout, cmderr, task.Stdout, task.Stderr)
+	err := master(ctx, task, f, v, u, p, cmdout, cmderr)
 	if err != nil {
-		return err
+		mapErr, ok := err.(MapError)
+		if !ok || !task.Chunk {
+			return err
+		}
+		// Need to re-map task errors.
+		taskErrs := make(map[int]error)
+		for i := range inds {
+			if err := mapErr.Tasks[i]; err != nil {
+				// Give error to all members.
+				for _, p := range inds[i] {
+					taskErrs[p] = err
+				}
+				continue
+			}
+			// No error occured. Move outputs.
+			for j, p := range inds[i] {
+				vij := reflect.ValueOf(v).Index(i).Index(j)
+				reflect.ValueOf(y).Index(p).Set(vij)
+			}
+		}
+		return MapError{mapErr.Master, taskErrs, n}
 	}
 
 	if task.Chunk {
@@ -0,0 +1,26 @@
+package dstrfn
+
+import "fmt"
+
+// MapError describes the failure of some elements of a map.
+type MapError struct {
+	// Error which prevented the jobs from finishing, if any.
+	Master error
+	// Errors of individual elements.
+	Tasks map[int]error
+	// Number of elements in the map.
+	Len int
+}
+
+func (err MapError) Error() string {
+	if err.Master != nil {
+		return fmt.Sprintf("%v: tasks failed %d/%d", err.Master, len(err.Tasks), err.Len)
+	}
+	return fmt.Sprintf("tasks failed %d/%d", len(err.Tasks), err.Len)
+}
+
+// Returns the error which prevented the jobs from finishing, if any.
+// For example, the error of a cancelled context.
+func (err MapError) Unwrap() error {
+	return err.Master
+}
@@ -1,158 +1,170 @@
 package dstrfn
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"reflect"
+	"strings"
 	"time"
 )
 
-func listenRetry(netstr, laddr string) net.Listener {
-	// TODO: This infinite loop is a hack.
-	for {
-		// Open port for server.
-		l, err := net.Listen(netstr, laddr)
-		if err == nil {
-			return l
-		}
-		log.Println(err)
-		// Pause.
-		time.Sleep(time.Second)
-		log.Println("listen: try again")
-	}
-}
-
 // The input x should be a slice.
 // The output y should be a slice with the exactly same number of elements.
-func master(task Task, name string, y, x, p interface{}, userargs []string, cmdout, cmderr io.Writer, jobout, joberr bool) error {
+//
+// If sub.Workers is positive, submits that many jobs (at most one per element),
+// each of which requests inputs until there are none left.
+// Otherwise submits one job per element, which exits after its element.
+//
+// Each input is given out as a lease which the slave renews with heartbeats.
+// If a lease expires, the input is given out again
+// until it has been leased sub.Attempts times.
+// If any element fails, the error is a MapError
+// which contains the error of every element that failed or never produced an output,
+// and y contains the outputs of the other elements.
+//
+// The requests of the slaves are received by the server of the process,
+// so several maps can run at the same time.
+//
+// If the context is done before the jobs finish, the jobs are deleted
+// and the error is a MapError whose Master is ctx.Err().
+func master(ctx context.Context, sub *subTask, name string, y, x, p interface{}, cmdout, cmderr io.Writer) error {
 	n := reflect.ValueOf(x).Len()
+	codec, err := lookupCodec(sub.Encoding)
+	if err != nil {
+		return err
+	}
+	lease := sub.Lease
+	if lease <= 0 {
+		lease = time.Minute
+	}
 
-	// Open port for server.
-	l := listenRetry("tcp", addrStr)
-	defer l.Close()
+	token, err := newToken()
+	if err != nil {
+		return fmt.Errorf("generate token: %v", err)
+	}
+	srv, err := defaultServer()
+	if err != nil {
+		return err
+	}
 
-	// Start server.
-	todo := make(chan int)
-	go func(n int) {
-		// Thread-safely obtain task IDs.
-		for i := 0; i < n; i++ {
-			todo <- i
-		}
-	}(n)
-	dsts := make(chan interface{})
-	go func(n int) {
-		// Thread-safely call NewOutput().
-		for i := 0; i < n; i++ {
-			dsts <- task.NewOutput()
-		}
-	}(n)
-	errs := make(chan error)
-	go serve(l, task, name, y, x, p, todo, dsts, errs)
+	// Start receiving requests.
+	q := newLeaseQueue(n, lease, sub.Attempts)
+	r := newRun(sub.Task, codec, token, lease, sub.Workers > 0, y, x, p, q)
+	srv.add(r, name)
+	errs := r.errs
 
 	// Submit job.
-	args := []string{"-dstrfn.task", name, "-dstrfn.addr", addrStr}
-	proc := make(chan error)
+	args := []string{
+		"-dstrfn.task", name,
+		"-dstrfn.addr", srv.Addr,
+		"-dstrfn.run", r.ID,
+		"-dstrfn.encoding", sub.Encoding,
+		"-dstrfn.heartbeat", (lease / 3).String(),
+		"-dstrfn.token", token,
+	}
+	if len(srv.CertHash) > 0 {
+		args = append(args, "-dstrfn.cert", srv.CertHash)
+	}
+
+	numJobs := n
+	if sub.Workers > 0 {
+		numJobs = min(sub.Workers, n)
+		args = append(args, "-dstrfn.loop")
+	}
+	userargs := strings.Split(sub.Flags, " ")
+	job, err := submit(numJobs, userargs, args, name, cmdout, cmderr, sub.Stdout, sub.Stderr)
+	if err != nil {
+		q.close()
+		srv.remove(r)
+		for range errs {
+		}
+		return err
+	}
+	proc := make(chan error, 1)
 	go func() {
-		proc <- submit(n, userargs, args, name, cmdout, cmderr, jobout, joberr)
+		proc <- job.Wait()
 	}()
 
 	// Wait for all tasks to finish.
 	// Do not exit if one task fails.
 	var (
-		num   int
-		first error
-		exit  bool
+		jobErr   error
+		numLost  int
+		taskErrs = make(map[int]error)
+		finished = make(map[int]bool)
 	)
-	for num < n && !exit {
-		select {
-		case err := <-errs:
-			if err != nil && first == nil {
-				first = err
-			}
-			n++
-		case err := <-proc:
-			if err != nil {
-				return err
+	record := func(res result) {
+		switch {
+		case res.Index < 0 && res.Err != nil:
+			// Error in the protocol, not the task.
+			// If an output was lost, it will be reported as missing.
+			log.Println("handle request:", res.Err)
+		case res.Err != nil:
+			taskErrs[res.Index] = res.Err
+		case res.Index >= 0:
+			finished[res.Index] = true
+		}
+	}
+	// Reports every element which neither finished nor failed.
+	missing := func() {
+		for i := 0; i < n; i++ {
+			if !finished[i] && taskErrs[i] == nil {
+				taskErrs[i] = fmt.Errorf("no output received: element %d", i)
 			}
-			exit = true
 		}
 	}
-	if first != nil {
-		return first
+	// Stops routing requests to the run
+	// and records the outputs which were still being received.
+	stop := func() {
+		q.close()
+		srv.remove(r)
+		for res := range errs {
+			record(res)
+		}
 	}
-	return nil
-}
 
-// The output x must be a slice.
-// The output y must be a slice of the same length.
-// The extra parameters p may be nil.
-func serve(l net.Listener, task Task, name string, y, x, p interface{}, todo <-chan int, dsts <-chan interface{}, errs chan<- error) {
+	tick := time.NewTicker(lease / 4)
+	defer tick.Stop()
+loop:
 	for {
-		conn, err := l.Accept()
-		// The listener will be closed when qsub exits.
-		if err != nil {
-			// TODO: Prevent this from printing an error when expected.
-			log.Println("accept:", err)
-			break
+		select {
+		case res := <-errs:
+			record(res)
+		case <-tick.C:
+			for i, err := range q.expire(time.Now()) {
+				log.Printf("lost input %d: %v", i, err)
+				taskErrs[i] = err
+				numLost++
+			}
+		case <-q.done:
+			// Every input has been resolved.
+			// The job of a lost input may never exit.
+			if numLost > 0 {
+				if err := job.Cancel(); err != nil {
+					log.Println("cancel:", err)
+				}
+			}
+			jobErr = <-proc
+			break loop
+		case jobErr = <-proc:
+			break loop
+		case <-ctx.Done():
+			if err := job.Cancel(); err != nil {
+				log.Println("cancel:", err)
+			}
+			// Wait for qsub to exit.
+			<-proc
+			stop()
+			missing()
+			return MapError{ctx.Err(), taskErrs, n}
 		}
-
-		go func(conn net.Conn) {
-			err := handleClose(conn, y, x, p, todo, dsts)
-			errs <- err
-		}(conn)
-	}
-}
-
-// Ensures the connection is closed before sending result down the channel.
-// Catches any errors that occur in conn.Close().
-func handleClose(conn net.Conn, y, x, p interface{}, todo <-chan int, dsts <-chan interface{}) error {
-	handleErr := handle(conn, y, x, p, todo, dsts)
-	closeErr := conn.Close()
-	if handleErr != nil {
-		return handleErr
-	}
-	if closeErr != nil {
-		return closeErr
-	}
-	return nil
-}
-
-// Sends one input or receives one output.
-func handle(rw io.ReadWriter, y, x, p interface{}, todo <-chan int, dsts <-chan interface{}) error {
-	// Read request.
-	req := new(request)
-	if err := json.NewDecoder(rw).Decode(req); err != nil {
-		return fmt.Errorf("receive request: %v", err)
 	}
-
-	switch req.Type {
-	default:
-		// Error occurred in protocol, not user code.
-		return fmt.Errorf(`unknown request type: "%s"`, req.Type)
-
-	case recvType:
-		i := <-todo
-		xi := reflect.ValueOf(x).Index(i).Interface()
-		resp := &inputResp{i, xi, p}
-		if err := json.NewEncoder(rw).Encode(resp); err != nil {
-			return fmt.Errorf("send input: %v", err)
-		}
-		return nil
-
-	case sendType:
-		body := &outputReq{Y: <-dsts}
-		if err := json.Unmarshal(req.Body, body); err != nil {
-			return fmt.Errorf("receive output: %v", err)
-		}
-		// Send the error if one occurred, nil otherwise.
-		if body.Err != nil {
-			return fmt.Errorf("slave error: %s", *body.Err)
-		}
-		// Assign value to output slice.
-		reflect.ValueOf(y).Index(body.Index).Set(reflect.ValueOf(body.Y).Elem())
-		return nil
+	stop()
+	missing()
+	if len(taskErrs) > 0 {
+		return MapError{jobErr, taskErrs, n}
 	}
+	return jobErr
 }
@@ -0,0 +1,476 @@
+package dstrfn
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	Register("square", false, Func(func(x float64) float64 { return x * x }))
+	Register("sqrt", false, Func(func(x float64) (float64, error) {
+		if x < 0 {
+			return 0, errors.New("negative")
+		}
+		return math.Sqrt(x), nil
+	}))
+	Register("panic-neg", false, Func(func(x float64) float64 {
+		if x < 0 {
+			panic("negative input")
+		}
+		return x
+	}))
+	Register("slow-square", false, Func(func(x float64) float64 {
+		time.Sleep(100 * time.Millisecond)
+		return x * x
+	}))
+	Register("sleep-ms", false, Func(func(x float64) float64 {
+		time.Sleep(time.Duration(x) * time.Millisecond)
+		return x
+	}))
+	Register("square-gob", false, Func(func(x float64) float64 { return x * x }), Encoding("gob"))
+	RegisterScheduler("go", goScheduler{})
+}
+
+// Runs each job as a goroutine in the current process.
+type goScheduler struct{}
+
+var (
+	// Number of jobs in the most recent array.
+	goSchedulerMu  sync.Mutex
+	goSchedulerLen int
+	// Number of jobs which die after receiving their first input.
+	goSchedulerDrop int
+	// Number of jobs which have exited.
+	goSchedulerExited int
+)
+
+func (goScheduler) Submit(spec *JobSpec) (Handle, error) {
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	var (
+		name, addr, encoding string
+		run, token, cert     string
+		beat                 time.Duration
+		loop                 bool
+	)
+	fs.StringVar(&name, "dstrfn.task", "", "")
+	fs.StringVar(&addr, "dstrfn.addr", "", "")
+	fs.StringVar(&encoding, "dstrfn.encoding", "json", "")
+	fs.StringVar(&run, "dstrfn.run", "", "")
+	fs.StringVar(&token, "dstrfn.token", "", "")
+	fs.StringVar(&cert, "dstrfn.cert", "", "")
+	fs.DurationVar(&beat, "dstrfn.heartbeat", 0, "")
+	fs.BoolVar(&loop, "dstrfn.loop", false, "")
+	if err := fs.Parse(spec.Args); err != nil {
+		return nil, err
+	}
+	e, err := newEndpoint(addr, encoding, run, token, cert)
+	if err != nil {
+		return nil, err
+	}
+	task := tasks[name].Task
+
+	goSchedulerMu.Lock()
+	goSchedulerLen = spec.Len
+	goSchedulerMu.Unlock()
+	h := &goHandle{errs: make(chan error, spec.Len), cancel: make(chan struct{})}
+	for i := 0; i < spec.Len; i++ {
+		h.wg.Add(1)
+		go func(i int) {
+			defer h.wg.Done()
+			defer func() {
+				goSchedulerMu.Lock()
+				goSchedulerExited++
+				goSchedulerMu.Unlock()
+			}()
+			if i < goSchedulerDrop {
+				// Take an input and never send the output.
+				xptr, pptr := task.NewInput(), task.NewConfig()
+				if _, err := receiveInput(e, xptr, pptr); err != nil {
+					h.errs <- err
+				}
+				return
+			}
+			for {
+				more, err := work(e, task, beat)
+				if err != nil {
+					h.errs <- err
+					return
+				}
+				if !more || !loop {
+					return
+				}
+			}
+		}(i)
+	}
+	return h, nil
+}
+
+type goHandle struct {
+	wg   sync.WaitGroup
+	errs chan error
+	// Closed by Cancel.
+	// The goroutines are not stopped, but their requests will be rejected.
+	cancel chan struct{}
+	once   sync.Once
+}
+
+func (h *goHandle) Wait() error {
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-h.cancel:
+		return errors.New("cancelled")
+	}
+	select {
+	case err := <-h.errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (h *goHandle) Cancel() error {
+	h.once.Do(func() { close(h.cancel) })
+	return nil
+}
+
+// Selects the in-process scheduler.
+func useGoScheduler(t *testing.T) {
+	addrStr = "127.0.0.1:0"
+	backend = "go"
+}
+
+// Stops the server of the process so that the next map starts a new one.
+func resetServer() {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	if procServer != nil {
+		procServer.l.Close()
+		procServer = nil
+	}
+}
+
+func TestMap_Workers(t *testing.T) {
+	useGoScheduler(t)
+	tasks["square"].Workers = 3
+	defer func() { tasks["square"].Workers = 0 }()
+
+	x := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	var y []float64
+	if err := MapContext(context.Background(), "square", &y, x, nil, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 4, 9, 16, 25, 36, 49, 64, 81, 100}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+	if goSchedulerLen != 3 {
+		t.Errorf("expect 3 jobs, got %d", goSchedulerLen)
+	}
+}
+
+// Without a pool, each job exits after its one input
+// instead of waiting for the slowest input to finish.
+func TestMap_JobsExit(t *testing.T) {
+	useGoScheduler(t)
+	goSchedulerMu.Lock()
+	goSchedulerExited = 0
+	goSchedulerMu.Unlock()
+
+	x := []float64{0, 0, 0, 500}
+	done := make(chan error, 1)
+	go func() {
+		var y []float64
+		done <- MapContext(context.Background(), "sleep-ms", &y, x, nil, ioutil.Discard, ioutil.Discard)
+	}()
+	for {
+		goSchedulerMu.Lock()
+		exited := goSchedulerExited
+		goSchedulerMu.Unlock()
+		if exited >= len(x)-1 {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("map finished before jobs exited: %d of %d exited, err %v", exited, len(x), err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMap_LeaseRequeue(t *testing.T) {
+	useGoScheduler(t)
+	sub := tasks["square"]
+	sub.Workers, sub.Lease, sub.Attempts = 3, 100*time.Millisecond, 2
+	goSchedulerDrop = 1
+	defer func() {
+		sub.Workers, sub.Lease, sub.Attempts = 0, time.Minute, 3
+		goSchedulerDrop = 0
+	}()
+
+	x := []float64{1, 2, 3, 4, 5}
+	var y []float64
+	if err := MapContext(context.Background(), "square", &y, x, nil, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 4, 9, 16, 25}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMap_LeaseLost(t *testing.T) {
+	useGoScheduler(t)
+	sub := tasks["square"]
+	sub.Workers, sub.Lease, sub.Attempts = 2, 100*time.Millisecond, 1
+	goSchedulerDrop = 1
+	defer func() {
+		sub.Workers, sub.Lease, sub.Attempts = 0, time.Minute, 3
+		goSchedulerDrop = 0
+	}()
+
+	x := []float64{1, 2, 3}
+	var y []float64
+	err := MapContext(context.Background(), "square", &y, x, nil, ioutil.Discard, ioutil.Discard)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 1 {
+		t.Fatalf("expect one lost input, got %v", mapErr.Tasks)
+	}
+	for i := range x {
+		if _, failed := mapErr.Tasks[i]; !failed && y[i] != x[i]*x[i] {
+			t.Errorf("expect outputs of other inputs, got %v", y)
+		}
+	}
+}
+
+// Heartbeats keep the lease of a slow input.
+func TestMap_LeaseHeartbeat(t *testing.T) {
+	useGoScheduler(t)
+	sub := tasks["slow-square"]
+	sub.Lease, sub.Attempts = 50*time.Millisecond, 1
+	defer func() { sub.Lease, sub.Attempts = time.Minute, 3 }()
+
+	x := []float64{1, 2}
+	var y []float64
+	if err := MapContext(context.Background(), "slow-square", &y, x, nil, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]float64{1, 4}, y) {
+		t.Errorf("expect [1 4], got %v", y)
+	}
+}
+
+// A cancelled map reports only its own elements
+// and stops routing requests to the run.
+func TestMapContext_Cancel(t *testing.T) {
+	useGoScheduler(t)
+	sub := tasks["slow-square"]
+	sub.Workers = 1
+	defer func() { sub.Workers = 0 }()
+
+	x := []float64{1, 2, 3, 4}
+	var y []float64
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	err := MapContext(ctx, "slow-square", &y, x, nil, ioutil.Discard, ioutil.Discard)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if mapErr.Master != context.DeadlineExceeded || mapErr.Len != len(x) {
+		t.Errorf("expect (%v, %d), got (%v, %d)", context.DeadlineExceeded, len(x), mapErr.Master, mapErr.Len)
+	}
+	if len(mapErr.Tasks) == 0 {
+		t.Error("expect missing elements")
+	}
+	for i := range mapErr.Tasks {
+		if i < 0 || i >= len(x) {
+			t.Errorf("unexpected element %d", i)
+		}
+	}
+	for i := range x {
+		if _, failed := mapErr.Tasks[i]; !failed && y[i] != x[i]*x[i] {
+			t.Errorf("expect outputs of finished elements, got %v", y)
+		}
+	}
+
+	srv, err := defaultServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.runs) != 0 {
+		t.Errorf("expect no runs, got %d", len(srv.runs))
+	}
+}
+
+func TestMap_Encoding(t *testing.T) {
+	useGoScheduler(t)
+	if enc := tasks["square-gob"].Encoding; enc != "gob" {
+		t.Fatalf(`expect encoding "gob", got "%s"`, enc)
+	}
+	x := []float64{1, 2, 3}
+	var y []float64
+	if err := MapContext(context.Background(), "square-gob", &y, x, nil, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]float64{1, 4, 9}, y) {
+		t.Errorf("expect [1 4 9], got %v", y)
+	}
+}
+
+func TestMap_TLS(t *testing.T) {
+	useGoScheduler(t)
+	useTLS = true
+	resetServer()
+	defer func() {
+		useTLS = false
+		resetServer()
+	}()
+
+	x := []float64{1, 2, 3}
+	var y []float64
+	if err := MapContext(context.Background(), "square", &y, x, nil, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]float64{1, 4, 9}, y) {
+		t.Errorf("expect [1 4 9], got %v", y)
+	}
+}
+
+func TestMap_Concurrent(t *testing.T) {
+	useGoScheduler(t)
+	x := []float64{1, 2, 3, 4}
+	var (
+		wg     sync.WaitGroup
+		y1, y2 []float64
+		errs   = make([]error, 2)
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = MapContext(context.Background(), "square", &y1, x, nil, ioutil.Discard, ioutil.Discard)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = MapContext(context.Background(), "slow-square", &y2, x, nil, ioutil.Discard, ioutil.Discard)
+	}()
+	wg.Wait()
+	want := []float64{1, 4, 9, 16}
+	for i, y := range [][]float64{y1, y2} {
+		if errs[i] != nil {
+			t.Errorf("map %d: %v", i, errs[i])
+			continue
+		}
+		if !reflect.DeepEqual(want, y) {
+			t.Errorf("map %d: expect %v, got %v", i, want, y)
+		}
+	}
+}
+
+func TestServer_InvalidToken(t *testing.T) {
+	srv, err := newServer("127.0.0.1:0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.l.Close()
+	codec, err := lookupCodec("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	y := make([]float64, 1)
+	q := newLeaseQueue(1, time.Minute, 1)
+	r := newRun(tasks["square"].Task, codec, "token", time.Minute, false, y, []float64{1}, nil, q)
+	srv.add(r, "square")
+
+	send := func(token string) {
+		e := &endpoint{Addr: srv.Addr, Codec: codec, Run: r.ID, Token: token}
+		if err := sendOutput(e, 0, 1.0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send("not-the-token")
+	select {
+	case res := <-r.errs:
+		t.Fatalf("expect request to be rejected, got %+v", res)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if y[0] != 0 {
+		t.Errorf("expect output to be rejected, got %v", y[0])
+	}
+
+	send("token")
+	if res := <-r.errs; res.Index != 0 || res.Err != nil {
+		t.Errorf("expect output 0, got %+v", res)
+	}
+	if y[0] != 1 {
+		t.Errorf("expect output 1, got %v", y[0])
+	}
+}
+
+func TestMap_TaskErrors(t *testing.T) {
+	useGoScheduler(t)
+	x := []float64{4, -1, 9, -4}
+	var y []float64
+	err := MapContext(context.Background(), "sqrt", &y, x, nil, ioutil.Discard, ioutil.Discard)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 2 || mapErr.Tasks[1] == nil || mapErr.Tasks[3] == nil {
+		t.Fatalf("expect errors for elements 1 and 3, got %v", mapErr.Tasks)
+	}
+	if msg := mapErr.Tasks[1].Error(); msg != "negative" {
+		t.Errorf(`expect "negative", got "%s"`, msg)
+	}
+	var taskErr *TaskError
+	if !errors.As(mapErr.Tasks[1], &taskErr) {
+		t.Fatalf("expect *TaskError, got %T", mapErr.Tasks[1])
+	}
+	if taskErr.Type != "*errors.errorString" || taskErr.Attempt != 1 {
+		t.Errorf(`expect type "*errors.errorString" and attempt 1, got %q and %d`, taskErr.Type, taskErr.Attempt)
+	}
+	if y[0] != 2 || y[2] != 3 {
+		t.Errorf("expect outputs of other elements, got %v", y)
+	}
+}
+
+func TestMap_Panic(t *testing.T) {
+	useGoScheduler(t)
+	x := []float64{1, -2, 3}
+	var y []float64
+	err := MapContext(context.Background(), "panic-neg", &y, x, nil, ioutil.Discard, ioutil.Discard)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 1 || mapErr.Tasks[1] == nil {
+		t.Fatalf("expect error for element 1 only, got %v", mapErr.Tasks)
+	}
+	msg := mapErr.Tasks[1].Error()
+	if !strings.Contains(msg, "panic: negative input") || !strings.Contains(msg, "goroutine") {
+		t.Errorf("expect panic value and stack, got %q", msg)
+	}
+	if y[0] != 1 || y[2] != 3 {
+		t.Errorf("expect outputs of other elements, got %v", y)
+	}
+}
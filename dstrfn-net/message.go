@@ -1,46 +1,114 @@
 package dstrfn
 
-import (
-	"encoding/json"
-)
+import "time"
 
+// Each connection begins with a header line containing the run ID and token
+// (see writeHeader), then the type of the request,
+// followed by the fields of a message in order.
+// All values are written using the codec of the task.
 const (
 	recvType = "recv"
 	sendType = "send"
+	// Renews the lease of an input.
+	// The body is the index of the input.
+	beatType = "beat"
 )
 
-// Returns a client request to receive input.
-func inputReq() *request {
-	return &request{recvType, json.RawMessage("null")}
-}
-
 // Describes a server response to send input.
+// The parameter P is only sent if the task has one.
+// A negative index means that there is no more work,
+// in which case only the index is sent.
 type inputResp struct {
 	Index int
 	X     interface{}
 	P     interface{}
 }
 
+func (r *inputResp) encode(enc Encoder, hasConfig bool) error {
+	if err := enc.Encode(r.Index); err != nil {
+		return err
+	}
+	if r.Index < 0 {
+		return nil
+	}
+	if err := enc.Encode(r.X); err != nil {
+		return err
+	}
+	if hasConfig {
+		return enc.Encode(r.P)
+	}
+	return nil
+}
+
+// X and P must be pointers to decode into.
+func (r *inputResp) decode(dec Decoder, hasConfig bool) error {
+	if err := dec.Decode(&r.Index); err != nil {
+		return err
+	}
+	if r.Index < 0 {
+		return nil
+	}
+	if err := dec.Decode(r.X); err != nil {
+		return err
+	}
+	if hasConfig {
+		return dec.Decode(r.P)
+	}
+	return nil
+}
+
 // Describes a client request to send output.
-// Use a string pointer for its ability to represent the nil error.
-// Cannot use error because it is an interface and
-// therefore not compatible with Marshal/Unmarshal.
+// A flag which indicates whether the task failed is sent first.
+// The output Y is only sent if there was no error,
+// otherwise the fields of the error are sent.
+// The attempt is not sent since it is known to the master.
 type outputReq struct {
 	Index int
 	Y     interface{}
-	Err   *string
+	Err   *TaskError
 }
 
-// Returns a generic request.
-func (r *outputReq) Generic() *request {
-	body, err := json.Marshal(r)
-	if err != nil {
-		panic(err)
+func (r *outputReq) encode(enc Encoder) error {
+	if err := enc.Encode(r.Index); err != nil {
+		return err
+	}
+	failed := r.Err != nil
+	if err := enc.Encode(failed); err != nil {
+		return err
+	}
+	if !failed {
+		return enc.Encode(r.Y)
 	}
-	return &request{sendType, json.RawMessage(body)}
+	// Fields are sent individually since not every codec supports structs.
+	fields := []interface{}{r.Err.Msg, r.Err.Type, r.Err.Host, r.Err.JobID, int64(r.Err.Wall)}
+	for _, v := range fields {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-type request struct {
-	Type string
-	Body json.RawMessage
+// Y must be a pointer to decode into.
+func (r *outputReq) decode(dec Decoder) error {
+	if err := dec.Decode(&r.Index); err != nil {
+		return err
+	}
+	var failed bool
+	if err := dec.Decode(&failed); err != nil {
+		return err
+	}
+	if !failed {
+		return dec.Decode(r.Y)
+	}
+	e := new(TaskError)
+	var wall int64
+	for _, v := range []interface{}{&e.Msg, &e.Type, &e.Host, &e.JobID, &wall} {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+	e.Wall = time.Duration(wall)
+	r.Err = e
+	return nil
 }
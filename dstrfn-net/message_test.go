@@ -0,0 +1,108 @@
+package dstrfn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMessage_Codecs(t *testing.T) {
+	for _, name := range []string{"json", "gob", "msgpack", "binary"} {
+		codec, err := lookupCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		enc := codec.NewEncoder(&b)
+		in := &inputResp{3, 1.5, 2.0}
+		if err := in.encode(enc, true); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		out := &outputReq{Index: 3, Y: 4.0}
+		if err := out.encode(enc); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		dec := codec.NewDecoder(&b)
+		var x, p float64
+		gotIn := &inputResp{X: &x, P: &p}
+		if err := gotIn.decode(dec, true); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if gotIn.Index != 3 || x != 1.5 || p != 2 {
+			t.Errorf("%s: expect (3, 1.5, 2), got (%d, %v, %v)", name, gotIn.Index, x, p)
+		}
+		var y float64
+		gotOut := &outputReq{Y: &y}
+		if err := gotOut.decode(dec); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if gotOut.Index != 3 || gotOut.Err != nil || y != 4 {
+			t.Errorf("%s: expect (3, nil, 4), got (%d, %v, %v)", name, gotOut.Index, gotOut.Err, y)
+		}
+	}
+}
+
+func TestMessage_BinaryLen(t *testing.T) {
+	codec, err := lookupCodec("binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Length prefix of a string followed by no data.
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, uint64(math.MaxUint64)); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := codec.NewDecoder(&b).Decode(&s); err == nil {
+		t.Error("expect error for huge length")
+	}
+}
+
+func TestMessage_TaskError(t *testing.T) {
+	for _, name := range []string{"json", "gob", "msgpack", "binary"} {
+		codec, err := lookupCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// An error with an empty message must not be mistaken for an output.
+		for _, msg := range []string{"negative", ""} {
+			var b bytes.Buffer
+			want := TaskError{Msg: msg, Type: "*errors.errorString", Host: "node1", JobID: "12.pbs", Wall: time.Second}
+			out := &outputReq{Index: 2, Err: &want}
+			if err := out.encode(codec.NewEncoder(&b)); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var y string
+			got := &outputReq{Y: &y}
+			if err := got.decode(codec.NewDecoder(&b)); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got.Index != 2 || got.Err == nil || *got.Err != want {
+				t.Errorf("%s: expect (2, %+v), got (%d, %+v)", name, want, got.Index, got.Err)
+			}
+		}
+	}
+}
+
+func TestMessage_NaN(t *testing.T) {
+	codec, err := lookupCodec("binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	out := &outputReq{Index: 0, Y: math.NaN()}
+	if err := out.encode(codec.NewEncoder(&b)); err != nil {
+		t.Fatal(err)
+	}
+	var y float64
+	got := &outputReq{Y: &y}
+	if err := got.decode(codec.NewDecoder(&b)); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(y) {
+		t.Errorf("expect NaN, got %v", y)
+	}
+}
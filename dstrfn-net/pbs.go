@@ -0,0 +1,118 @@
+package dstrfn
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Submits jobs to PBS Pro using qsub.
+type pbsScheduler struct{}
+
+func (pbsScheduler) Submit(spec *JobSpec) (Handle, error) {
+	var args []string
+	// Set task name.
+	args = append(args, "-N", spec.Name)
+	// Set number of jobs.
+	if spec.Len > 1 {
+		args = append(args, "-J", fmt.Sprintf("1-%d", spec.Len))
+	}
+	// Wait for all jobs to finish.
+	args = append(args, "-Wblock=true")
+	// Use same environment variables.
+	args = append(args, "-V")
+	// Where to send stdout and stderr.
+	switch {
+	case spec.JobOut && spec.JobErr:
+		args = append(args, "-k", "n")
+	case spec.JobOut:
+		args = append(args, "-k", "e")
+	case spec.JobErr:
+		args = append(args, "-k", "o")
+	default:
+		args = append(args, "-k", "oe")
+	}
+	// Set resources.
+	if len(spec.Flags) > 0 {
+		args = append(args, spec.Flags...)
+	}
+	args = append(args, "--", spec.Path)
+	args = append(args, spec.Args...)
+
+	// Submit.
+	cmd := exec.Command("qsub", args...)
+	// Stdout is read to obtain the job ID.
+	cmd.Stderr = spec.CmdErr
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprint(&b, "qsub")
+	for _, arg := range args {
+		fmt.Fprint(&b, " ", arg)
+	}
+	log.Println("invoke:", b.String())
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// qsub prints the job ID on submission, then blocks.
+	cmdout := spec.CmdOut
+	if cmdout == nil {
+		cmdout = ioutil.Discard
+	}
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	fmt.Fprint(cmdout, line)
+	id := strings.TrimSpace(line)
+	if err != nil || len(id) == 0 {
+		// Job was not submitted.
+		io.Copy(cmdout, br)
+		if err := cmd.Wait(); err != nil {
+			return nil, fmt.Errorf("qsub: %v", err)
+		}
+		return nil, fmt.Errorf("qsub: could not read job ID")
+	}
+
+	job := &pbsJob{ID: id, cmd: cmd, copied: make(chan struct{})}
+	go func() {
+		io.Copy(cmdout, br)
+		close(job.copied)
+	}()
+	return job, nil
+}
+
+// Array of jobs submitted to PBS Pro.
+type pbsJob struct {
+	// Job ID printed by qsub.
+	ID     string
+	cmd    *exec.Cmd
+	copied chan struct{}
+}
+
+// Waits for the blocking qsub command to exit.
+func (j *pbsJob) Wait() error {
+	// Stdout must be consumed before calling cmd.Wait().
+	<-j.copied
+	return j.cmd.Wait()
+}
+
+// Deletes the job using qdel.
+// The blocking qsub command exits once the job has been deleted.
+func (j *pbsJob) Cancel() error {
+	out, err := exec.Command("qdel", j.ID).CombinedOutput()
+	// Do not wait for qsub to notice.
+	j.cmd.Process.Kill()
+	if err != nil {
+		return fmt.Errorf("qdel: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
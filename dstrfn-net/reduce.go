@@ -1,17 +1,21 @@
 package dstrfn
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
 )
 
-// Reduce is implemented as log(n) maps from lists of value pairs to lists of values.
-// Each map corresponds to one level of a binary tree.
+// Reduce is implemented as log_k(n) maps from lists of groups to lists of values.
+// Each map corresponds to one level of a k-ary tree,
+// where k is set by the flag -name.arity.
 //
 // The input x is a slice of type []T and the output y is a pointer of type *T.
 // The function f is identified by name and must already be registered.
-// The task should map a Pair of elements of type T to a single T.
+// If the task was created by ReduceFunc, it maps a slice []T of up to k elements to a single T.
+// Otherwise it maps a Pair of elements of type T to a single T and k is 2.
 //
 // Reduce calls ReduceWriteTo with DefaultCmdOut, DefaultCmdErr.
 func Reduce(f string, y, x, p interface{}) error {
@@ -19,7 +23,13 @@ func Reduce(f string, y, x, p interface{}) error {
 }
 
 func ReduceWriteTo(f string, y, x, p interface{}, cmdout, cmderr io.Writer) error {
-	out, err := reduce(f, x, p, cmdout, cmderr)
+	return ReduceContext(context.Background(), f, y, x, p, cmdout, cmderr)
+}
+
+// ReduceContext is like ReduceWriteTo but deletes the jobs if the context is done first.
+// The error is then the MapError of the level of the tree which was interrupted.
+func ReduceContext(ctx context.Context, f string, y, x, p interface{}, cmdout, cmderr io.Writer) error {
+	out, err := reduce(ctx, f, x, p, cmdout, cmderr)
 	if err != nil {
 		return err
 	}
@@ -27,26 +37,73 @@ func ReduceWriteTo(f string, y, x, p interface{}, cmdout, cmderr io.Writer) erro
 	return nil
 }
 
-func reduce(f string, x, p interface{}, cmdout, cmderr io.Writer) (interface{}, error) {
+func reduce(ctx context.Context, f string, x, p interface{}, cmdout, cmderr io.Writer) (interface{}, error) {
 	// If there is only one element, return it.
 	// Panics if the input list was empty.
 	xval := reflect.ValueOf(x)
 	if xval.Len() < 2 {
 		return xval.Index(0).Interface(), nil
 	}
-	y, err := halve(f, x, p, cmdout, cmderr)
+	task, there := tasks[f]
+	if !there {
+		return nil, fmt.Errorf(`task not found: "%s"`, f)
+	}
+	var (
+		y   interface{}
+		err error
+	)
+	if task.Grouped {
+		y, err = shrink(ctx, f, x, p, max(task.Arity, 2), cmdout, cmderr)
+	} else {
+		y, err = halve(ctx, f, x, p, cmdout, cmderr)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return reduce(f, y, p, cmdout, cmderr)
+	return reduce(ctx, f, y, p, cmdout, cmderr)
 }
 
-// Maps n elements to ceil(n/2) elements.
+// Maps n elements to ceil(n/k) elements.
 // The input x must be a slice.
 // Returns a slice of the same type.
-func halve(f string, x, p interface{}, cmdout, cmderr io.Writer) (interface{}, error) {
+func shrink(ctx context.Context, f string, x, p interface{}, k int, cmdout, cmderr io.Writer) (interface{}, error) {
 	xval := reflect.ValueOf(x)
-	n := reflect.ValueOf(x).Len()
+	n := xval.Len()
+	// The last group may be smaller than the others.
+	// A group of one element does not need to be submitted.
+	m := ceilDiv(n, k)
+	if n%k == 1 {
+		m--
+	}
+
+	// Construct a list of groups of the same type as x.
+	groups := reflect.MakeSlice(reflect.SliceOf(xval.Type()), m, m)
+	for i := 0; i < m; i++ {
+		groups.Index(i).Set(xval.Slice(i*k, min((i+1)*k, n)))
+	}
+
+	// Make a slice to assign the results to.
+	// Includes capacity for the last element if it was not submitted.
+	yptr := reflect.New(reflect.TypeOf(x)).Interface()
+	y := reflect.ValueOf(yptr).Elem()
+	y.Set(reflect.MakeSlice(reflect.TypeOf(x), m, m+1))
+	if err := MapContext(ctx, f, yptr, groups.Interface(), p, cmdout, cmderr); err != nil {
+		return nil, err
+	}
+	// Bring the last element forward.
+	if m*k < n {
+		y = reflect.Append(y, xval.Index(n-1))
+	}
+	return y.Interface(), nil
+}
+
+// Maps n elements to ceil(n/2) elements using a Pair for each job.
+// Used for tasks which were not created by ReduceFunc.
+// The input x must be a slice.
+// Returns a slice of the same type.
+func halve(ctx context.Context, f string, x, p interface{}, cmdout, cmderr io.Writer) (interface{}, error) {
+	xval := reflect.ValueOf(x)
+	n := xval.Len()
 	floor, ceil := n/2, (n+1)/2
 
 	pairs := make([]Pair, floor)
@@ -57,12 +114,11 @@ func halve(f string, x, p interface{}, cmdout, cmderr io.Writer) (interface{}, e
 	}
 
 	// Make a slice to assign the results to.
-	// If n is even, then n/2 == (n+1)/2.
 	// If n is odd, then this includes capacity for the last element.
 	yptr := reflect.New(reflect.TypeOf(x)).Interface()
 	y := reflect.ValueOf(yptr).Elem()
 	y.Set(reflect.MakeSlice(reflect.TypeOf(x), floor, ceil))
-	if err := MapWriteTo(f, yptr, pairs, p, cmdout, cmderr); err != nil {
+	if err := MapContext(ctx, f, yptr, pairs, p, cmdout, cmderr); err != nil {
 		return nil, err
 	}
 	// If there were an odd number of elements,
@@ -75,13 +131,15 @@ func halve(f string, x, p interface{}, cmdout, cmderr io.Writer) (interface{}, e
 
 // Pair describes a pair of values.
 //
-// Reduce tasks are executed as maps from pairs to single elements.
+// Reduce gives each job a Pair if the task was not created by ReduceFunc.
+// Tasks created by ReduceFunc are given a slice of up to k elements instead.
 type Pair struct {
 	A, B interface{}
 }
 
 // ReduceFunc creates a reduce task from a function.
-// A reduce task maps a Pair of values to a single value.
+// A reduce task maps a slice of values to a single value.
+// The task combines the elements two at a time from left to right.
 //
 // The function f must take either two or three arguments and
 // have either one or two return values.
@@ -104,6 +162,8 @@ type Pair struct {
 // and therefore we define them in terms of their two-input case alone.
 //
 // ReduceFunc tasks should be invoked using Reduce() not Map().
+// Registering a ReduceFunc task creates the flag -name.arity,
+// which sets the number of elements combined by each job.
 func ReduceFunc(f interface{}) Task {
 	fval := reflect.ValueOf(f)
 	if fval.Kind() != reflect.Func {
@@ -123,12 +183,10 @@ type reduceFuncTask struct {
 	F interface{}
 }
 
-// Returns a Pair containing the types of the first two arguments.
+// Returns a slice of the type of the first argument.
 func (t *reduceFuncTask) NewInput() interface{} {
 	f := reflect.ValueOf(t.F)
-	a := reflect.New(f.Type().In(0)).Interface()
-	b := reflect.New(f.Type().In(1)).Interface()
-	return &Pair{a, b}
+	return reflect.New(reflect.SliceOf(f.Type().In(0))).Interface()
 }
 
 // Returns a new object of the type of the third argument.
@@ -147,30 +205,33 @@ func (t *reduceFuncTask) NewOutput() interface{} {
 	return reflect.New(f.Type().Out(0)).Interface()
 }
 
-// If function only takes one argument then p is ignored.
+// Combines the elements of x from left to right.
+// If function only takes two arguments then p is ignored.
 func (t *reduceFuncTask) Func(x, p interface{}) (interface{}, error) {
 	f := reflect.ValueOf(t.F)
-	// Get two elements from x. Panics if x is not a Pair.
-	ab := x.(Pair)
-	in := []reflect.Value{
-		reflect.ValueOf(ab.A).Elem(),
-		reflect.ValueOf(ab.B).Elem(),
-	}
-	// Only use third argument if function accepts one.
-	if f.Type().NumIn() > 2 {
-		in = append(in, reflect.ValueOf(p))
-	}
-	// Panics if call is invalid.
-	out := f.Call(in)
-	// Panics if f has no return values.
-	y := out[0].Interface()
-	if len(out) == 1 {
-		return y, nil
-	}
-	err := out[1].Interface()
-	if err == nil {
-		return y, nil
-	}
-	// Panics if second return value is not assignable to error.
-	return y, err.(error)
+	// Panics if x is not a slice.
+	xval := reflect.ValueOf(x)
+	if xval.Len() == 0 {
+		return nil, errors.New("reduce empty group")
+	}
+	y := xval.Index(0)
+	for i := 1; i < xval.Len(); i++ {
+		in := []reflect.Value{y, xval.Index(i)}
+		// Only use third argument if function accepts one.
+		if f.Type().NumIn() > 2 {
+			in = append(in, reflect.ValueOf(p))
+		}
+		// Panics if call is invalid.
+		out := f.Call(in)
+		// Panics if f has no return values.
+		y = out[0]
+		if len(out) == 1 {
+			continue
+		}
+		if err := out[1].Interface(); err != nil {
+			// Panics if second return value is not assignable to error.
+			return y.Interface(), err.(error)
+		}
+	}
+	return y.Interface(), nil
 }
@@ -0,0 +1,47 @@
+package dstrfn
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func init() {
+	Register("add", false, ReduceFunc(func(x, y float64) float64 { return x + y }))
+	// Reduce tasks which were not created by ReduceFunc take a Pair.
+	Register("add-pair", false, Func(func(x Pair) float64 {
+		return x.A.(float64) + x.B.(float64)
+	}))
+}
+
+func TestReduceFunc_Group(t *testing.T) {
+	task := ReduceFunc(func(x, y, p float64) float64 { return x + p*y })
+	// Decode the input as the slave does.
+	xptr := task.NewInput()
+	if err := json.Unmarshal([]byte("[1, 2, 3]"), xptr); err != nil {
+		t.Fatal(err)
+	}
+	x := reflect.ValueOf(xptr).Elem().Interface()
+	y, err := task.Func(x, 2.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y != 11.0 {
+		t.Errorf("expect 11, got %v", y)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	useGoScheduler(t)
+	x := []float64{1, 2, 3, 4, 5}
+	for _, f := range []string{"add", "add-pair"} {
+		var y float64
+		if err := Reduce(f, &y, x, nil); err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if y != 15 {
+			t.Errorf("%s: expect 15, got %v", f, y)
+		}
+	}
+}
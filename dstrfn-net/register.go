@@ -3,18 +3,34 @@ package dstrfn
 import (
 	"flag"
 	"fmt"
+	"time"
 )
 
 var (
-	tasks     map[string]*subTask
-	addrStr   string
-	slaveTask string
+	tasks          map[string]*subTask
+	addrStr        string
+	slaveTask      string
+	slaveEncoding  string
+	slaveHeartbeat time.Duration
+	slaveRun       string
+	slaveToken     string
+	slaveCert      string
+	slaveLoop      bool
+	// Use TLS for connections to the master?
+	useTLS bool
 )
 
 func init() {
 	tasks = make(map[string]*subTask)
-	flag.StringVar(&addrStr, "dstrfn.addr", "", "Address of master on network.")
+	flag.StringVar(&addrStr, "dstrfn.addr", "", "Address of master on network. Empty to choose a port and detect the host.")
 	flag.StringVar(&slaveTask, "dstrfn.task", "", "Task to execute as slave. Empty to execute as master.")
+	flag.StringVar(&slaveEncoding, "dstrfn.encoding", "json", "Codec of messages to and from the master.")
+	flag.StringVar(&slaveRun, "dstrfn.run", "", "ID of the map to which the slave belongs.")
+	flag.StringVar(&slaveToken, "dstrfn.token", "", "Token with which the slave authenticates to the master.")
+	flag.StringVar(&slaveCert, "dstrfn.cert", "", "SHA-256 fingerprint of the certificate of the master. Empty for plain TCP.")
+	flag.BoolVar(&useTLS, "dstrfn.tls", false, "Encrypt connections between the master and slaves using TLS.")
+	flag.BoolVar(&slaveLoop, "dstrfn.loop", false, "Request inputs until the master has no more work.")
+	flag.DurationVar(&slaveHeartbeat, "dstrfn.heartbeat", 20*time.Second, "Interval at which to renew the lease of an input.")
 }
 
 // Task for submission.
@@ -28,20 +44,40 @@ type subTask struct {
 	ChunkLen int
 	// Keep stdout and stderr of tasks?
 	Stdout, Stderr bool
+	// Number of elements combined by each job of a reduce.
+	Arity int
+	// Does each job of a reduce take a slice of up to Arity elements?
+	// Otherwise it takes a Pair.
+	Grouped bool
+	// Name of the codec for messages.
+	Encoding string
+	// Number of long-lived workers, or zero for one job per input.
+	Workers int
+	// Duration of the lease of each input
+	// and maximum number of times each input is leased.
+	Lease    time.Duration
+	Attempts int
 }
 
+// Option modifies the default settings of a task.
+// The settings can still be overridden using command-line flags.
+type Option func(*subTask)
+
 // Registers a task to a name.
 // The name must be able to be part of a command-line flag.
 //
 // Chunking is only supported for "simple" types.
 // That is, types X which can be decoded from JSON into new([]X).
-func Register(name string, chunk bool, task Task) {
+func Register(name string, chunk bool, task Task, opts ...Option) {
 	_, used := tasks[name]
 	if used {
 		panic(fmt.Sprintf(`name already registered: "%s"`, name))
 	}
 
-	st := new(subTask)
+	st := &subTask{Encoding: "json"}
+	for _, opt := range opts {
+		opt(st)
+	}
 	if chunk {
 		st.Task = &chunkTask{task}
 		st.Chunk = true
@@ -52,5 +88,14 @@ func Register(name string, chunk bool, task Task) {
 	flag.StringVar(&st.Flags, name+".flags", "", "Additional flags")
 	flag.BoolVar(&st.Stdout, name+".stdout", false, "Keep stdout?")
 	flag.BoolVar(&st.Stderr, name+".stderr", false, "Keep stderr?")
+	flag.StringVar(&st.Encoding, name+".encoding", st.Encoding, "Codec for inputs and outputs (json, gob, msgpack, binary).")
+	flag.IntVar(&st.Workers, name+".workers", 0, "Number of jobs which each process inputs until there are none left. Zero for one job per input.")
+	flag.DurationVar(&st.Lease, name+".lease", time.Minute, "Time after the last heartbeat at which an input is given to another slave.")
+	flag.IntVar(&st.Attempts, name+".attempts", 3, "Maximum number of times each input is leased.")
+	st.Arity = 2
+	if _, ok := task.(*reduceFuncTask); ok {
+		st.Grouped = true
+		flag.IntVar(&st.Arity, name+".arity", 2, "Number of elements combined by each job. At least 2.")
+	}
 	tasks[name] = st
 }
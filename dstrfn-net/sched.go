@@ -0,0 +1,70 @@
+package dstrfn
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Scheduler submits arrays of jobs to a batch system.
+//
+// The scheduler is selected by name using the -dstrfn.backend flag.
+// The default is PBS Pro.
+type Scheduler interface {
+	// Submits an array of jobs and returns without waiting for them.
+	Submit(spec *JobSpec) (Handle, error)
+}
+
+// Handle refers to an array of jobs which has been submitted.
+type Handle interface {
+	// Blocks until all jobs in the array have finished.
+	// Must be called exactly once.
+	Wait() error
+	// Removes all jobs in the array from the system.
+	Cancel() error
+}
+
+// JobSpec describes an array of jobs.
+type JobSpec struct {
+	// Name of the job.
+	Name string
+	// Number of jobs in the array.
+	Len int
+	// Absolute path of the executable and the arguments to invoke it with.
+	Path string
+	Args []string
+	// Additional flags for the scheduler.
+	Flags []string
+	// Where to route stdout and stderr of the submission command.
+	CmdOut, CmdErr io.Writer
+	// Keep stdout and stderr of the jobs?
+	JobOut, JobErr bool
+}
+
+var (
+	schedulers = make(map[string]Scheduler)
+	backend    string
+)
+
+func init() {
+	flag.StringVar(&backend, "dstrfn.backend", "pbs", "Scheduler to which jobs are submitted.")
+	RegisterScheduler("pbs", pbsScheduler{})
+}
+
+// Makes a scheduler available to the -dstrfn.backend flag.
+// The name must not already be in use.
+func RegisterScheduler(name string, sched Scheduler) {
+	if _, used := schedulers[name]; used {
+		panic(fmt.Sprintf(`scheduler already registered: "%s"`, name))
+	}
+	schedulers[name] = sched
+}
+
+// Returns the scheduler selected by -dstrfn.backend.
+func scheduler() (Scheduler, error) {
+	sched, there := schedulers[backend]
+	if !there {
+		return nil, fmt.Errorf(`scheduler not found: "%s"`, backend)
+	}
+	return sched, nil
+}
@@ -0,0 +1,298 @@
+package dstrfn
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time allowed for a slave to send the header of a request.
+const headerTimeout = time.Minute
+
+// Accepts the connections of every map in the process
+// and routes each request to its map by the run ID in the header.
+type server struct {
+	l net.Listener
+	// Address at which slaves can reach the server.
+	Addr string
+	// Fingerprint of the certificate if TLS is used, empty otherwise.
+	CertHash string
+
+	mu   sync.Mutex
+	runs map[string]*run
+	seq  int
+}
+
+var (
+	serverMu   sync.Mutex
+	procServer *server
+)
+
+// Returns the server of the process, starting it on first use.
+// The server listens at -dstrfn.addr and uses TLS if -dstrfn.tls is set.
+func defaultServer() (*server, error) {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	if procServer != nil {
+		return procServer, nil
+	}
+	s, err := newServer(addrStr, useTLS)
+	if err != nil {
+		return nil, err
+	}
+	procServer = s
+	return s, nil
+}
+
+func newServer(laddr string, secure bool) (*server, error) {
+	l, addr, err := listen(laddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %v", err)
+	}
+	log.Println("listen:", addr)
+	s := &server{Addr: addr, runs: make(map[string]*run)}
+	if secure {
+		c, hash, err := masterCert()
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("generate certificate: %v", err)
+		}
+		l = tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{c}})
+		s.CertHash = hash
+	}
+	s.l = l
+	go s.serve()
+	return s, nil
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			// The listener was closed.
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// Registers a run and assigns its ID.
+func (s *server) add(r *run, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	r.ID = fmt.Sprintf("%s-%d", name, s.seq)
+	s.runs[r.ID] = r
+}
+
+// Stops routing requests to a run.
+// Closes its results once every request which was routed to it has been handled.
+func (s *server) remove(r *run) {
+	s.mu.Lock()
+	delete(s.runs, r.ID)
+	s.mu.Unlock()
+	go func() {
+		r.wg.Wait()
+		close(r.errs)
+	}()
+}
+
+// Finds the run of a request and checks its token.
+// The caller must call r.wg.Done() once the request has been handled.
+func (s *server) acquire(id, token string) (*run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, there := s.runs[id]
+	if !there {
+		return nil, fmt.Errorf(`unknown run: "%s"`, id)
+	}
+	if !validToken(token, r.Token) {
+		return nil, errInvalidToken
+	}
+	r.wg.Add(1)
+	return r, nil
+}
+
+// Requests with an unknown run or invalid token are only logged,
+// so that an impostor cannot cause a map to fail.
+func (s *server) handleConn(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(headerTimeout))
+	br := bufio.NewReader(conn)
+	id, token, err := readHeader(br)
+	if err != nil {
+		log.Println("receive header:", err)
+		conn.Close()
+		return
+	}
+	r, err := s.acquire(id, token)
+	if err != nil {
+		log.Println("reject request:", err)
+		conn.Close()
+		return
+	}
+	defer r.wg.Done()
+	// Do not wait forever for a slave which has died.
+	conn.SetReadDeadline(time.Now().Add(r.Timeout))
+	index, err := r.handle(struct {
+		io.Reader
+		io.Writer
+	}{br, conn})
+	if err := conn.Close(); err != nil {
+		log.Println("close:", err)
+	}
+	r.errs <- result{index, err}
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// Every request begins with a line containing the run ID and the token.
+// The header is not encoded using the codec
+// since the codec is a property of the run.
+func writeHeader(w io.Writer, id, token string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", id, token)
+	return err
+}
+
+func readHeader(r *bufio.Reader) (id, token string, err error) {
+	// ReadSlice limits the length of the line to the size of the buffer.
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return "", "", err
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) != 2 {
+		return "", "", errors.New("malformed header")
+	}
+	return fields[0], fields[1], nil
+}
+
+// State of one map on the server.
+type run struct {
+	ID    string
+	Token string
+	// Requests which are not received within the timeout are abandoned.
+	Timeout time.Duration
+	// Are the slaves a pool of workers which request inputs until there are none left?
+	// If not, each slave processes one input and exits.
+	Pool bool
+
+	task      Task
+	codec     Codec
+	hasConfig bool
+	// The output y must be a slice of the same length as x.
+	// The extra parameters p may be nil.
+	y, x, p interface{}
+	q       *leaseQueue
+	// Receives the outcome of each request.
+	errs chan result
+	// Counts the requests which are being handled.
+	wg sync.WaitGroup
+	// Thread-safely call NewOutput().
+	mu sync.Mutex
+}
+
+func newRun(task Task, codec Codec, token string, timeout time.Duration, pool bool, y, x, p interface{}, q *leaseQueue) *run {
+	return &run{
+		Token:     token,
+		Timeout:   timeout,
+		Pool:      pool,
+		task:      task,
+		codec:     codec,
+		hasConfig: task.NewConfig() != nil,
+		y:         y,
+		x:         x,
+		p:         p,
+		q:         q,
+		errs:      make(chan result),
+	}
+}
+
+func (r *run) newOutput() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.task.NewOutput()
+}
+
+// Outcome of handling one connection.
+type result struct {
+	// Index of the output which was received, or -1 if none was.
+	Index int
+	Err   error
+}
+
+// Sends one input, receives one output or renews one lease.
+// Returns the index of the output if one was received, -1 otherwise.
+// Outputs of inputs which were already resolved are ignored.
+func (r *run) handle(rw io.ReadWriter) (int, error) {
+	// Read request.
+	// The same decoder must be used for the body since it may buffer.
+	dec := r.codec.NewDecoder(rw)
+	var typ string
+	if err := dec.Decode(&typ); err != nil {
+		return -1, fmt.Errorf("receive request: %v", err)
+	}
+
+	switch typ {
+	default:
+		// Error occurred in protocol, not user code.
+		return -1, fmt.Errorf(`unknown request type: "%s"`, typ)
+
+	case recvType:
+		// A slave which is not in a pool holds its job until it exits,
+		// so it must not wait for an input which may be put back on the queue.
+		i, ok := r.q.next(r.Pool)
+		if !ok {
+			resp := &inputResp{Index: -1}
+			if err := resp.encode(r.codec.NewEncoder(rw), r.hasConfig); err != nil {
+				return -1, fmt.Errorf("send end of input: %v", err)
+			}
+			return -1, nil
+		}
+		xi := reflect.ValueOf(r.x).Index(i).Interface()
+		resp := &inputResp{i, xi, r.p}
+		if err := resp.encode(r.codec.NewEncoder(rw), r.hasConfig); err != nil {
+			return -1, fmt.Errorf("send input: %v", err)
+		}
+		return -1, nil
+
+	case beatType:
+		var i int
+		if err := dec.Decode(&i); err != nil {
+			return -1, fmt.Errorf("receive heartbeat: %v", err)
+		}
+		r.q.renew(i)
+		return -1, nil
+
+	case sendType:
+		body := &outputReq{Y: r.newOutput()}
+		if err := body.decode(dec); err != nil {
+			return -1, fmt.Errorf("receive output: %v", err)
+		}
+		// Number of times the input has been leased, including this one.
+		attempt := r.q.leased(body.Index)
+		first := r.q.complete(body.Index, func() {
+			if body.Err == nil {
+				// Assign value to output slice.
+				reflect.ValueOf(r.y).Index(body.Index).Set(reflect.ValueOf(body.Y).Elem())
+			}
+		})
+		if !first {
+			log.Printf("ignore output %d: already received", body.Index)
+			return -1, nil
+		}
+		// Send the error if one occurred, nil otherwise.
+		if body.Err != nil {
+			body.Err.Attempt = attempt
+			return body.Index, body.Err
+		}
+		return body.Index, nil
+	}
+}
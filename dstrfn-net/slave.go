@@ -1,13 +1,15 @@
 package dstrfn
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-	"net"
 	"os"
 	"reflect"
+	"runtime"
+	"time"
 )
 
 // If the process is a slave, this function never returns.
@@ -22,11 +24,16 @@ func ExecIfSlave() {
 		panic(fmt.Errorf("task not found: %#v", slaveTask))
 	}
 
-	slave(sub.Task)
+	e, err := newEndpoint(addrStr, slaveEncoding, slaveRun, slaveToken, slaveCert)
+	if err != nil {
+		panic(err)
+	}
+	slave(sub.Task, e, slaveLoop)
 	os.Exit(0)
 }
 
-func slave(task Task) {
+// If loop is false, the slave processes a single input.
+func slave(task Task, e *endpoint, loop bool) {
 	dir := os.Getenv("PBS_O_WORKDIR")
 	if len(dir) == 0 {
 		panic("environment variable empty: PBS_O_WORKDIR")
@@ -35,13 +42,32 @@ func slave(task Task) {
 		panic(fmt.Sprintf("chdir: %v", err))
 	}
 
+	for {
+		more, err := work(e, task, slaveHeartbeat)
+		if err != nil {
+			panic(err)
+		}
+		if !more || !loop {
+			return
+		}
+	}
+}
+
+// Requests one input from the master, calls the function and sends the output.
+// Sends a heartbeat at every interval while the function is running.
+// Returns false if the master had no more work.
+func work(e *endpoint, task Task, interval time.Duration) (bool, error) {
 	// Request input from the master.
 	xptr := task.NewInput()
 	pptr := task.NewConfig()
 	log.Println("receive input")
-	index, err := receiveInput(addrStr, xptr, pptr)
+	index, err := receiveInput(e, xptr, pptr)
 	if err != nil {
-		panic(err)
+		return false, err
+	}
+	if index < 0 {
+		log.Println("no more input")
+		return false, nil
 	}
 
 	x := reflect.ValueOf(xptr).Elem().Interface()
@@ -50,59 +76,116 @@ func slave(task Task) {
 		p = reflect.ValueOf(pptr).Elem().Interface()
 	}
 	log.Println("call function")
-	y, taskerr := task.Func(x, p)
+	stop := make(chan struct{})
+	go heartbeat(e, index, interval, stop)
+	start := time.Now()
+	y, err := callFunc(task, x, p)
+	close(stop)
+	var taskErr *TaskError
+	if err != nil {
+		taskErr = newTaskError(err, time.Since(start))
+	}
 
 	log.Println("send output")
-	if err := sendOutput(addrStr, index, y, taskerr); err != nil {
-		panic(err)
+	if err := sendOutput(e, index, y, taskErr); err != nil {
+		return false, err
 	}
+	return true, nil
 }
 
 // Populates the values referenced by x and p.
-// Returns the task index.
-func receiveInput(addr string, x, p interface{}) (int, error) {
-	conn, err := net.Dial("tcp", addr)
+// If p is nil, no parameter is received.
+// Returns the task index, or -1 if there is no more input.
+func receiveInput(e *endpoint, x, p interface{}) (int, error) {
+	// Send (empty) input request.
+	conn, _, err := e.request(recvType)
 	if err != nil {
-		return 0, errors.New("connect to server: " + err.Error())
+		return 0, err
 	}
 	defer conn.Close()
 
-	// Send (empty) input request.
-	req := inputReq()
-	if err := json.NewEncoder(conn).Encode(req); err != nil {
-		return 0, errors.New("send input request: " + err.Error())
-	}
-
 	// Decode response.
 	resp := &inputResp{X: x, P: p}
-	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+	if err := resp.decode(e.Codec.NewDecoder(conn), p != nil); err != nil {
 		return 0, errors.New("decode input response: " + err.Error())
 	}
 	return resp.Index, nil
 }
 
-func sendOutput(addr string, index int, y interface{}, taskerr error) error {
-	conn, err := net.Dial("tcp", addr)
+// Renews the lease of an input until stop is closed.
+// Failures are only logged since the lease may be renewed next time.
+func heartbeat(e *endpoint, index int, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := sendBeat(e, index); err != nil {
+				log.Println("heartbeat:", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func sendBeat(e *endpoint, index int) error {
+	conn, enc, err := e.request(beatType)
 	if err != nil {
-		return errors.New("connect to server: " + err.Error())
+		return err
 	}
 	defer conn.Close()
 
-	req := &outputReq{index, y, errToStr(taskerr)}
-	if err := json.NewEncoder(conn).Encode(req.Generic()); err != nil {
+	if err := enc.Encode(index); err != nil {
+		return errors.New("send heartbeat: " + err.Error())
+	}
+	return nil
+}
+
+func sendOutput(e *endpoint, index int, y interface{}, taskErr *TaskError) error {
+	conn, enc, err := e.request(sendType)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &outputReq{index, y, taskErr}
+	if err := req.encode(enc); err != nil {
 		return errors.New("send output request: " + err.Error())
 	}
 
-	// No data to receive. Done.
+	// No data to receive.
+	// Wait for the master to close the connection
+	// so that the output has been received before the slave exits.
+	conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	io.Copy(ioutil.Discard, conn)
 	return nil
 }
 
-// Assumes no difference between nil error and empty string
-// for serialization purposes.
-func errToStr(err error) *string {
-	if err == nil {
-		return nil
-	}
-	s := err.Error()
-	return &s
+// Time for which a slave waits for the master to close a connection.
+const closeTimeout = time.Minute
+
+// Calls the function of the task.
+// A panic is recovered and returned as an error
+// which contains the panic value and the stack of the goroutine.
+func callFunc(task Task, x, p interface{}) (y interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			err = panicError{r, buf[:runtime.Stack(buf, false)]}
+		}
+	}()
+	return task.Func(x, p)
+}
+
+type panicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (err panicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", err.Value, err.Stack)
 }
@@ -0,0 +1,46 @@
+package dstrfn
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// TaskError describes the failure of one element on a slave.
+// It is sent to the master with the output request
+// and returned in MapError.Tasks.
+type TaskError struct {
+	// Message of the error returned by the function.
+	Msg string
+	// Go type of the error, for example "*os.PathError".
+	Type string
+	// Host on which the slave ran.
+	Host string
+	// Identifier of the job assigned by the scheduler, empty if unknown.
+	JobID string
+	// Attempt on which the element failed, starting at one.
+	// Set by the master from the number of times the input was leased.
+	Attempt int
+	// Time taken by the function before it failed.
+	Wall time.Duration
+}
+
+func (err *TaskError) Error() string {
+	return err.Msg
+}
+
+func newTaskError(err error, wall time.Duration) *TaskError {
+	host, _ := os.Hostname()
+	return &TaskError{
+		Msg:   err.Error(),
+		Type:  fmt.Sprintf("%T", err),
+		Host:  host,
+		JobID: jobID(),
+		Wall:  wall,
+	}
+}
+
+// Returns the ID of the current job, empty if not run by PBS.
+func jobID() string {
+	return os.Getenv("PBS_JOBID")
+}
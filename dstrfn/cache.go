@@ -0,0 +1,215 @@
+package dstrfn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"reflect"
+)
+
+var cacheDir string
+
+func init() {
+	flag.StringVar(&cacheDir, "dstrfn.cache", "", "Directory in which to cache outputs. Empty to disable the cache.")
+}
+
+// Version sets the version of a task for the purpose of caching.
+// Change the version whenever the function is modified
+// so that outputs computed by the old function are not re-used.
+func Version(v string) Option {
+	return func(spec *taskSpec) {
+		spec.Version = v
+	}
+}
+
+// Returns the key under which the output of f(x, p) is cached.
+// The key is a hash of the task name and version and the encoded input and config.
+func cacheKey(f, version, encoding string, x, p interface{}) (string, error) {
+	xdata, err := encode(encoding, x)
+	if err != nil {
+		return "", fmt.Errorf("encode input: %v", err)
+	}
+	var pdata []byte
+	if !isNil(p) {
+		pdata, err = encode(encoding, p)
+		if err != nil {
+			return "", fmt.Errorf("encode config: %v", err)
+		}
+	}
+	h := sha256.New()
+	for _, b := range [][]byte{[]byte(f), []byte(version), []byte(encoding), xdata, pdata} {
+		// Prefix each part with its length so that the parts cannot be confused.
+		fmt.Fprintf(h, "%d:", len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Reports whether v is nil or a nil slice, map or pointer.
+// MapFunc passes an empty list of parameters as a nil slice.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch val := reflect.ValueOf(v); val.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr:
+		return val.IsNil()
+	}
+	return false
+}
+
+// Encodes a value in memory.
+func encode(encoding string, v interface{}) ([]byte, error) {
+	codec, err := lookupCodec(encoding)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := codec.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// The extension ext determines the codec and compression of the file.
+func cacheFile(f, key, ext string) string {
+	return path.Join(cacheDir, f, key+"."+ext)
+}
+
+// Attempts to load a cached output into y.
+// Returns false if the output was not in the cache.
+func cacheLoad(f, key, ext string, y interface{}) bool {
+	file := cacheFile(f, key, ext)
+	if _, err := os.Stat(file); err != nil {
+		return false
+	}
+	if err := loadFile(file, y); err != nil {
+		log.Printf("load from cache: %v", err)
+		return false
+	}
+	return true
+}
+
+// Saves an output to the cache.
+// The file is written under a temporary name and then renamed
+// so that an interrupted write is never mistaken for an output.
+func cacheSave(f, key, ext string, y interface{}) error {
+	if err := os.MkdirAll(path.Join(cacheDir, f), 0755); err != nil {
+		return err
+	}
+	return saveFileAtomic(cacheFile(f, key, ext), y)
+}
+
+// Loads the outputs of a map which are in the cache
+// and submits the remaining elements using mapAsyncChunk.
+// The outputs of the submitted elements are added to the cache
+// once they have been loaded.
+func mapAsyncCache(task *mapTaskSpec, f string, y, x, p interface{}, flags []string) (*Job, error) {
+	n := reflect.ValueOf(x).Len()
+	y = ensureLenAndDeref(y, n)
+	// y now has correct len, is not a pointer, and can be modified.
+	ext, err := fileExt(task.Encoding, task.Compress)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, n)
+	var miss []int
+	for i := 0; i < n; i++ {
+		key, err := cacheKey(f, task.Version, task.Encoding, reflect.ValueOf(x).Index(i).Interface(), p)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %v", i, err)
+		}
+		keys[i] = key
+		yi := reflect.ValueOf(y).Index(i).Addr().Interface()
+		if !cacheLoad(f, key, ext, yi) {
+			miss = append(miss, i)
+		}
+	}
+	log.Printf("cache: %d of %d elements found", n-len(miss), n)
+	if len(miss) == 0 {
+		// The map does not need a directory,
+		// but whether it is found in the cache may differ when the program is resumed.
+		reserveMapSeq(f)
+		job := newJob(finishedHandle{}, "")
+		job.keep = true
+		job.next = func(execErr error) (Handle, error) { return nil, execErr }
+		job.poll = func(seen func(int) bool, emit func(int, reflect.Value)) {
+			for i := 0; i < n; i++ {
+				if !seen(i) {
+					emit(i, reflect.ValueOf(y).Index(i))
+				}
+			}
+		}
+		return job, nil
+	}
+
+	missed := make(map[int]bool)
+	for _, i := range miss {
+		missed[i] = true
+	}
+
+	// Map only the elements which were not found.
+	xtyp := reflect.SliceOf(reflect.TypeOf(x).Elem())
+	u := reflect.MakeSlice(xtyp, len(miss), len(miss))
+	for j, i := range miss {
+		u.Index(j).Set(reflect.ValueOf(x).Index(i))
+	}
+	v := reflect.New(reflect.TypeOf(y))
+	job, err := mapAsyncChunk(task, f, v.Interface(), u.Interface(), p, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	next := job.next
+	job.next = func(execErr error) (Handle, error) {
+		handle, err := next(execErr)
+		if handle != nil {
+			return handle, nil
+		}
+		mapErr, isMapErr := err.(MapError)
+		if err != nil && !isMapErr {
+			return nil, err
+		}
+		taskErrs := make(map[int]error)
+		attempts := make(map[int]int)
+		for j, i := range miss {
+			if isMapErr {
+				if err := mapErr.Tasks[j]; err != nil {
+					taskErrs[i] = err
+					attempts[i] = mapErr.Attempts[j]
+					continue
+				}
+			}
+			// Element was computed.
+			vj := v.Elem().Index(j)
+			reflect.ValueOf(y).Index(i).Set(vj)
+			if err := cacheSave(f, keys[i], ext, vj.Interface()); err != nil {
+				log.Printf("save to cache: %v", err)
+			}
+		}
+		if !isMapErr {
+			return nil, nil
+		}
+		return nil, MapError{mapErr.Master, taskErrs, n, attempts}
+	}
+
+	poll := job.poll
+	job.poll = func(seen func(int) bool, emit func(int, reflect.Value)) {
+		// Elements which were found in the cache are available immediately.
+		for i := 0; i < n; i++ {
+			if !missed[i] && !seen(i) {
+				emit(i, reflect.ValueOf(y).Index(i))
+			}
+		}
+		poll(func(j int) bool { return seen(miss[j]) }, func(j int, vj reflect.Value) {
+			emit(miss[j], vj)
+		})
+	}
+	return job, nil
+}
@@ -1,6 +1,7 @@
 package dstrfn
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -29,71 +30,125 @@ func Args(x ...interface{}) []interface{} {
 	return x
 }
 
-// Call calls the function and saves the output to the specified file.
-// It does not load the result into memory.
-// If the file already exists, it does not call the function.
+// Call calls the function and loads the output into y.
+// If -dstrfn.cache is set and the output is in the cache,
+// it does not call the function.
 func Call(f string, y, x interface{}, stdout, stderr io.Writer, flags []string) error {
+	return CallContext(context.Background(), f, y, x, stdout, stderr, flags)
+}
+
+// CallContext is like Call but cancels the job if the context is done first.
+// The error is then ctx.Err().
+func CallContext(ctx context.Context, f string, y, x interface{}, stdout, stderr io.Writer, flags []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	job, err := CallAsync(f, y, x, stdout, stderr, flags)
+	if err != nil {
+		return err
+	}
+	return job.WaitContext(ctx)
+}
+
+// CallAsync submits the job for Call and returns without waiting for it.
+// The output is loaded into y by Job.Wait(),
+// which returns the same errors as Call.
+func CallAsync(f string, y, x interface{}, stdout, stderr io.Writer, flags []string) (*Job, error) {
 	task, there := tasks[f]
 	if !there {
-		return fmt.Errorf(`task not found: "%s"`, f)
+		return nil, fmt.Errorf(`task not found: "%s"`, f)
+	}
+
+	ext, err := fileExt(task.Encoding, task.Compress)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look for output in cache.
+	var key string
+	if len(cacheDir) > 0 && y != nil {
+		key, err = cacheKey(f, task.Version, task.Encoding, x, nil)
+		if err != nil {
+			return nil, err
+		}
+		if cacheLoad(f, key, ext, y) {
+			job := newJob(finishedHandle{}, "")
+			job.keep = true
+			job.next = func(execErr error) (Handle, error) { return nil, execErr }
+			return job, nil
+		}
 	}
 
 	// Create temporary directory.
 	dir, err := ioutil.TempDir(".", f+"-")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	inFile := path.Join(dir, "in.json")
-	outFile := path.Join(dir, "out.json")
+	inFile := path.Join(dir, "in."+ext)
+	outFile := path.Join(dir, "out."+ext)
 	errFile := path.Join(dir, "err.json")
 	// Save input.
-	if err := fileutil.SaveJSON(inFile, x); err != nil {
-		return err
+	if err := saveFile(inFile, x); err != nil {
+		return nil, err
 	}
 
-	// Invoke qsub.
-	jobargs := []string{"-dstrfn.task", f, "-dstrfn.dir", dir}
+	// Submit job.
+	jobargs := []string{"-dstrfn.task", f, "-dstrfn.dir", dir, "-dstrfn.encoding", task.Encoding}
+	if len(task.Compress) > 0 {
+		jobargs = append(jobargs, "-dstrfn.compress", task.Compress)
+	}
 	if len(flags) > 0 {
 		jobargs = append(jobargs, flags...)
 	}
-	execErr, err := submit(1, jobargs, f, dir, task.Flags, stdout, stderr)
+	handle, err := submit(1, jobargs, f, dir, task.Flags, stdout, stderr)
 	if err != nil {
-		return err
-	}
-	if execErr != nil {
-		return execErr
+		return nil, err
 	}
 
-	if _, err := os.Stat(errFile); err == nil {
-		// Error file exists. Attempt to load.
-		var str string
-		if err := fileutil.LoadExt(errFile, &str); err != nil {
-			return fmt.Errorf("load error file: %v", err)
+	collect := func(execErr error) error {
+		if execErr != nil {
+			return execErr
 		}
-		return errors.New(str)
-	} else if !os.IsNotExist(err) {
-		// Could not stat file.
-		return fmt.Errorf("stat error file: %v", err)
-	}
-	// Error file does not exist.
-
-	if y != nil {
-		// Output required.
-		if _, err := os.Stat(outFile); os.IsNotExist(err) {
-			return errors.New("could not find output or error files")
-		} else if err != nil {
-			return fmt.Errorf("stat output file: %v", err)
+		if _, err := os.Stat(errFile); err == nil {
+			// Error file exists. Attempt to load.
+			taskErr := &TaskError{Attempt: 1}
+			if err := fileutil.LoadExt(errFile, taskErr); err != nil {
+				return fmt.Errorf("load error file: %v", err)
+			}
+			return taskErr
+		} else if !os.IsNotExist(err) {
+			// Could not stat file.
+			return fmt.Errorf("stat error file: %v", err)
 		}
-		if err := fileutil.LoadExt(outFile, y); err != nil {
-			return err
+		// Error file does not exist.
+
+		if y != nil {
+			// Output required.
+			if _, err := os.Stat(outFile); os.IsNotExist(err) {
+				return errors.New("could not find output or error files")
+			} else if err != nil {
+				return fmt.Errorf("stat output file: %v", err)
+			}
+			if err := loadFile(outFile, y); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
-	// Only remove temporary directory if there was no error.
-	if !debug {
-		return removeAll(dir)
+	job := newJob(handle, dir)
+	job.next = func(execErr error) (Handle, error) {
+		if err := collect(execErr); err != nil {
+			return nil, err
+		}
+		if len(key) > 0 {
+			if err := cacheSave(f, key, ext, y); err != nil {
+				log.Printf("save to cache: %v", err)
+			}
+		}
+		return nil, nil
 	}
-	return nil
+	return job, nil
 }
 
 func removeAll(fname string) error {
@@ -10,6 +10,14 @@ type chunkTask struct {
 	Task ConfigTask
 }
 
+// Returns the task which a chunked task performs on each element.
+func unchunk(task ConfigTask) ConfigTask {
+	if c, ok := task.(*chunkTask); ok {
+		return c.Task
+	}
+	return task
+}
+
 // Creates a new input element, discards it,
 // and creates a new slice of the type that it pointed to.
 func (t *chunkTask) NewInput() interface{} {
@@ -0,0 +1,94 @@
+package dstrfn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestCodec_NaN(t *testing.T) {
+	x := []float64{1, math.Inf(-1), math.NaN()}
+	for _, name := range []string{"gob", "msgpack", "binary"} {
+		var b bytes.Buffer
+		if err := codecs[name].NewEncoder(&b).Encode(x); err != nil {
+			t.Errorf("%s: encode: %v", name, err)
+			continue
+		}
+		var y []float64
+		if err := codecs[name].NewDecoder(&b).Decode(&y); err != nil {
+			t.Errorf("%s: decode: %v", name, err)
+			continue
+		}
+		if len(y) != 3 || y[0] != 1 || !math.IsInf(y[1], -1) || !math.IsNaN(y[2]) {
+			t.Errorf("%s: expect %v, got %v", name, x, y)
+		}
+	}
+}
+
+// Tasks with several arguments decode into a list of pointers.
+func TestCodec_Args(t *testing.T) {
+	x := []interface{}{"abc", 3, []float64{1, 2}}
+	for _, name := range []string{"json", "msgpack", "binary"} {
+		var b bytes.Buffer
+		if err := codecs[name].NewEncoder(&b).Encode(x); err != nil {
+			t.Errorf("%s: encode: %v", name, err)
+			continue
+		}
+		var (
+			s string
+			n int
+			v []float64
+		)
+		y := []interface{}{&s, &n, &v}
+		if err := codecs[name].NewDecoder(&b).Decode(&y); err != nil {
+			t.Errorf("%s: decode: %v", name, err)
+			continue
+		}
+		if s != "abc" || n != 3 || !reflect.DeepEqual(v, []float64{1, 2}) {
+			t.Errorf("%s: expect %v, got %v %v %v", name, x, s, n, v)
+		}
+	}
+}
+
+func TestCodec_BinaryLen(t *testing.T) {
+	// Length prefix of a slice followed by no data.
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, uint64(math.MaxUint64)); err != nil {
+		t.Fatal(err)
+	}
+	var y []float64
+	if err := codecs["binary"].NewDecoder(&b).Decode(&y); err == nil {
+		t.Error("expect error for huge length")
+	}
+}
+
+func TestSaveFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := path.Join(dir, "out-0.json")
+	if err := saveFileAtomic(fname, 3.5); err != nil {
+		t.Fatal(err)
+	}
+	var y float64
+	if err := loadFile(fname, &y); err != nil {
+		t.Fatal(err)
+	}
+	if y != 3.5 {
+		t.Errorf("expect 3.5, got %v", y)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expect only the output file, got %d files", len(files))
+	}
+}
@@ -0,0 +1,86 @@
+package dstrfn
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of input and output files.
+// The extension is appended to that of the codec, for example in-0.json.gz.
+type compressor struct {
+	Ext       string
+	NewWriter func(io.Writer) (io.WriteCloser, error)
+	NewReader func(io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[string]compressor{
+	"gzip": {
+		Ext:       "gz",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	"zstd": {
+		Ext: "zst",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+}
+
+// Compress sets the compression of the input and output files of a task.
+// The name is "gzip" or "zstd".
+// The default is no compression.
+// The flag -name.compress overrides this setting.
+func Compress(name string) Option {
+	return func(spec *taskSpec) {
+		spec.Compress = name
+	}
+}
+
+// Returns the extension of files which are encoded and compressed as given.
+// An empty compress denotes no compression.
+func fileExt(encoding, compress string) (string, error) {
+	if _, err := lookupCodec(encoding); err != nil {
+		return "", err
+	}
+	if len(compress) == 0 {
+		return encoding, nil
+	}
+	c, there := compressors[compress]
+	if !there {
+		return "", fmt.Errorf(`compression not found: "%s"`, compress)
+	}
+	return encoding + "." + c.Ext, nil
+}
+
+// Determines the codec and compression of a file from its extension.
+// The compressor is nil if the file is not compressed.
+func parseExt(fname string) (Codec, *compressor, error) {
+	name := path.Base(fname)
+	var comp *compressor
+	for _, c := range compressors {
+		if strings.HasSuffix(name, "."+c.Ext) {
+			name = strings.TrimSuffix(name, "."+c.Ext)
+			c := c
+			comp = &c
+			break
+		}
+	}
+	codec, err := lookupCodec(strings.TrimPrefix(path.Ext(name), "."))
+	if err != nil {
+		return nil, nil, err
+	}
+	return codec, comp, nil
+}
@@ -0,0 +1,54 @@
+package dstrfn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSaveFile_Compress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	x := []string{"abc", "abc", "abc", "abc"}
+	magic := map[string][]byte{
+		"gzip": {0x1f, 0x8b},
+		"zstd": {0x28, 0xb5, 0x2f, 0xfd},
+	}
+	for comp, want := range magic {
+		ext, err := fileExt("json", comp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fname := path.Join(dir, "x."+ext)
+		if err := saveFile(fname, x); err != nil {
+			t.Fatalf("%s: %v", comp, err)
+		}
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, want) {
+			t.Errorf("%s: file is not compressed: %q", comp, data)
+		}
+		var y []string
+		if err := loadFile(fname, &y); err != nil {
+			t.Fatalf("%s: %v", comp, err)
+		}
+		if !reflect.DeepEqual(x, y) {
+			t.Errorf("%s: expect %v, got %v", comp, x, y)
+		}
+	}
+}
+
+func TestFileExt_Unknown(t *testing.T) {
+	if _, err := fileExt("json", "brotli"); err == nil {
+		t.Error("expect error for unknown compression")
+	}
+}
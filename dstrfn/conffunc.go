@@ -85,7 +85,10 @@ func (t *mapTask) Func(x, p interface{}) (interface{}, error) {
 	in := []reflect.Value{reflect.ValueOf(x)}
 	// Append additional arguments if there are any.
 	if ftyp.NumIn() > 1 {
-		args := p.([]interface{})
+		args, ok := p.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config has type %T, expect []interface{}", p)
+		}
 		for _, arg := range args {
 			// De-reference each element.
 			in = append(in, reflect.ValueOf(arg).Elem())
@@ -60,11 +60,126 @@ The address must be in a format which can be used with net.Listen() and net.Dial
 The -task.flags option provides a way to specify task-dependent flags to qsub.
 	$ ./example [...] -square.flags "-l mem=100m,walltime=0:15:00"
 The -task.stdout and -task.stderr flags provide a way to keep the stdout and stderr files generated by the slaves.
-The -task.chunk-len flag only appears if the dstrfn.Register() was called with chunk set to true.
+The -task.chunk-len flag has an effect if dstrfn.RegisterMap() was called with chunk set to true
+or the task was registered with the dstrfn.Chunk(true) option.
 It provides a way to perform multiple maps per task.
 Note that only functions with concrete types can be used with chunking.
 Specifically, types X which can be decoded from JSON into an empty slice of type []X.
 
+Schedulers
+
+Jobs are submitted through a Scheduler.
+The default is PBS Pro, which is selected by -dstrfn.backend=pbs.
+Slurm is selected by -dstrfn.backend=slurm, in which case jobs are submitted using sbatch.
+Other schedulers can be added using dstrfn.RegisterScheduler() and selected by name.
+The -task.flags option is passed to the submission command of whichever scheduler is used.
+
+The local scheduler (-dstrfn.backend=local) runs each job as a subprocess on the same machine.
+This makes it possible to test a program without access to a cluster.
+The flag -dstrfn.local-procs limits the number of processes which run at once.
+
+Asynchronous jobs
+
+MapAsync() and CallAsync() submit the jobs and return a *Job without waiting.
+The Job can report the number of jobs in each state, cancel the jobs, or wait for them.
+	job, err := dstrfn.MapAsync("square", &y, x, nil, os.Stderr, os.Stderr, nil)
+	// ...
+	status, err := job.Status()
+	// ...
+	err = job.Wait()
+The outputs are not available until Wait() has returned.
+
+MapContext() and CallContext() delete the jobs if the context is done before they finish.
+A cancelled map returns a MapError whose Master is ctx.Err(),
+which reports the elements that did not finish.
+
+MapStream() submits the jobs and sends the result of each element on a channel as soon as its output appears.
+	results, err := dstrfn.MapStream("square", x, nil)
+	// ...
+	for r := range results {
+		// Use r.Index, r.Value and r.Err.
+	}
+The run directory is checked every -dstrfn.poll.
+
+Retrying failed elements
+
+A map task can be registered with a retry policy.
+	dstrfn.RegisterMap("square", false, sqr, dstrfn.Retry(3, time.Minute))
+Elements which fail are submitted again as a smaller array, up to three attempts in total.
+The delay before each retry doubles.
+The flags -task.attempts and -task.backoff override the policy.
+A MapError only contains the elements which failed on every attempt,
+and Attempts records how many times each of them was tried.
+
+If the function panics, the worker recovers and reports the element as failed.
+The error in the MapError contains the panic value and the stack trace of the worker.
+
+The error of each failed element is a *TaskError,
+which also records the host, the job ID, the attempt, the time taken
+and the Go type of the original error.
+	var taskErr *dstrfn.TaskError
+	if errors.As(mapErr.Tasks[i], &taskErr) {
+		log.Printf("element %d failed on %s: %v", i, taskErr.Host, taskErr)
+	}
+
+Resuming a map
+
+If the flag -dstrfn.resume=dir is given,
+each map is performed in a directory within dir which is not removed afterwards.
+When the program is run again with the same flag,
+the outputs which already exist are loaded and only the missing elements are submitted.
+The directories are named by task and by the number of previous maps of that task,
+so the program must perform the same maps in the same order.
+A run is not resumed if its task, length, chunk size, inputs or config have changed.
+
+Caching outputs
+
+If the flag -dstrfn.cache=dir is given, the outputs of Call and Map are saved in dir.
+The key of each output is a hash of the task name, the task version, the input and the config.
+Elements whose outputs are already in the cache are not submitted.
+The version is set when the task is registered.
+	dstrfn.RegisterMap("square", false, sqr, dstrfn.Version("2"))
+Change the version whenever the function changes.
+
+Encoding
+
+Inputs, parameters and outputs are written to files using a codec.
+The default is JSON, which cannot represent NaN or infinity.
+Other codecs are selected when the task is registered or by the flag -task.encoding.
+	dstrfn.RegisterMap("square", true, sqr, dstrfn.Encoding("msgpack"))
+	$ ./example [...] -square.encoding=binary
+The built-in codecs are json, gob, msgpack and binary.
+Gob cannot decode a list of arguments, so it cannot be used with Func of several arguments.
+Binary only supports fixed-size values, strings and slices of these.
+Further codecs can be added using dstrfn.RegisterCodec().
+
+The input and output files can also be compressed using gzip or zstd.
+	dstrfn.RegisterMap("square", true, sqr, dstrfn.Compress("zstd"))
+	$ ./example [...] -square.compress=gzip
+The extension of each file records its codec and compression, for example in-0.json.gz,
+so that the files in a debug directory can still be decoded.
+
+Packed files
+
+By default, a map writes one input file and one output file for every element.
+A task registered with dstrfn.Pack(true) or run with -task.pack
+writes every input to a single file with an index which each job seeks into.
+Each job appends its outputs to one segment with an index of their locations,
+and the master appends the segments to a single output file.
+A job of a chunked task performs -task.chunk-len elements and writes one segment,
+so a map of n elements creates about n/chunk-len segments, which are removed once merged.
+
+Typed tasks
+
+NewMapTask() and NewTask() register a function with concrete types
+and return a handle whose methods accept and return those types.
+	var pow = dstrfn.NewMapTask("pow", func(x, p float64) (float64, error) {
+		return math.Pow(x, p), nil
+	}, dstrfn.Chunk(true))
+	// ...
+	y, err := pow.Map(ctx, x, 2)
+Passing an input or output of the wrong type is then a compile error.
+
 Additional parameters
 
 To call a function accepting one additional parameter which is constant for all x[i]:
@@ -75,16 +190,54 @@ To call a function accepting one additional parameter which is constant for all
 
 Reduce operations
 
-Reduce operations are performed as a series of maps from a list of Pairs of X to a list of Xs.
-Tasks for reducing are registered in the same way as those for mapping, however they must be called using dstrfn.Reduce() not dstrfn.Map().
-Note that reduce operations defined with ReduceFunc() are incompatible with chunking because Pair has members of type interface{}.
+Reduce operations are performed as a series of maps from a list of groups []X to a list of Xs.
+Tasks for reducing are registered using dstrfn.RegisterReduce() and called using dstrfn.Reduce().
+Because the groups are typed, reduce operations can be chunked.
+
+The flag -task.arity=k sets the number of elements in each group (default 2).
+Each job combines its k elements locally, so a reduce of n elements requires log_k(n) submissions.
 
 To do a reduce operation:
-	dstrfn.Register("add", false, dstrfn.ReduceFunc(
+	dstrfn.RegisterReduce("add", true, dstrfn.ReduceFunc(
 		func(x, y float64) float64 { return x + y },
 	))
 	// ...
 	var total float64
-	err := dstrfn.Reduce("add", &total, x, nil)
+	err := dstrfn.Reduce("add", &total, x, nil, os.Stdout, os.Stderr, nil)
+
+Additional arguments of the function are held constant, as for a map.
+	dstrfn.RegisterReduce("norm", true, dstrfn.ReduceFunc(
+		func(x, y, p float64) float64 {
+			return math.Pow(math.Pow(x, p)+math.Pow(y, p), 1/p)
+		},
+	))
+	// ...
+	err := dstrfn.Reduce("norm", &norm, x, dstrfn.Args(1.5), os.Stdout, os.Stderr, nil)
+
+FlatMap and Filter
+
+FlatMap() concatenates the outputs of a task which returns a slice []Y.
+Filter() keeps the elements of x for which a task returns true.
+	dstrfn.RegisterMap("positive", true, dstrfn.Func(func(x float64) bool { return x > 0 }))
+	// ...
+	var pos []float64
+	err := dstrfn.Filter("positive", &pos, x, nil, os.Stdout, os.Stderr, nil)
+Both preserve the order of x and support chunking.
+
+MapReduce
+
+MapReduce() maps each element to a map[K]V and then reduces the values of each key.
+	dstrfn.RegisterMap("count", false, dstrfn.Func(
+		func(s string) map[string]int { return map[string]int{s: 1} },
+	))
+	dstrfn.RegisterReduce("add-int", false, dstrfn.ReduceFunc(
+		func(x, y int) int { return x + y },
+	))
+	// ...
+	var counts map[string]int
+	err := dstrfn.MapReduce("count", "add-int", &counts, words, nil, os.Stdout, os.Stderr, nil)
+The master writes the values to partition files by the hash of their key,
+and a single array of jobs then groups and reduces the values of each partition.
+The flag -task.partitions sets the number of partitions, which is at most 256.
 */
 package dstrfn
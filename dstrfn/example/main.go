@@ -3,7 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
-	//"math"
+	"math"
 	"os"
 
 	"github.com/jvlmdr/go-pbs-pro/dstrfn"
@@ -32,20 +32,20 @@ func main() {
 	dstrfn.RegisterMap("add-const", true, dstrfn.ConfigFunc(
 		func(x, y float64) float64 { return x + y },
 	))
-	//	// Reduce operation with no extra arguments.
-	//	dstrfn.Register("add", false, dstrfn.ReduceFunc(
-	//		func(x, y float64) float64 { return x + y },
-	//	))
-	//	// Reduce operation with one extra argument.
-	//	dstrfn.Register("norm", false, dstrfn.ReduceFunc(
-	//		func(x, y, p float64) float64 {
-	//			return math.Pow(math.Pow(x, p)+math.Pow(y, p), 1/p)
-	//		},
-	//	))
+	// Reduce operation with no extra arguments.
+	dstrfn.RegisterReduce("add", true, dstrfn.ReduceFunc(
+		func(x, y float64) float64 { return x + y },
+	))
+	// Reduce operation with one extra argument.
+	dstrfn.RegisterReduce("norm", true, dstrfn.ReduceFunc(
+		func(x, y, p float64) float64 {
+			return math.Pow(math.Pow(x, p)+math.Pow(y, p), 1/p)
+		},
+	))
 
 	dstrfn.RegisterMap("vec-2-norm", true, dstrfn.Func(Norm))
 	dstrfn.RegisterMap("vec-p-norm", true, dstrfn.ConfigFunc(NormP))
-	//dstrfn.Register("vec-add", false, dstrfn.ReduceFunc(AddVec))
+	dstrfn.RegisterReduce("vec-add", false, dstrfn.ReduceFunc(AddVec))
 
 	flag.Parse()
 	dstrfn.ExecIfSlave()
@@ -92,22 +92,22 @@ func main() {
 	}
 	fmt.Println(z)
 
-	//	// Compute sum of all numbers in a list.
-	//	var sum float64
-	//	if err := dstrfn.Reduce("add", &sum, x, nil); err != nil {
-	//		fmt.Fprintln(os.Stderr, "reduce:", err)
-	//		os.Exit(1)
-	//	}
-	//	fmt.Println("sum:", sum)
-
-	//	// Compute 1.5-norm.
-	//	// Demonstrates reduce function with a parameter.
-	//	var norm float64
-	//	if err := dstrfn.Reduce("norm", &norm, x, 1.5); err != nil {
-	//		fmt.Fprintln(os.Stderr, "reduce:", err)
-	//		os.Exit(1)
-	//	}
-	//	fmt.Println("1.5-norm:", norm)
+	// Compute sum of all numbers in a list.
+	var sum float64
+	if err := dstrfn.Reduce("add", &sum, x, nil, dstrfn.DefaultStdout, dstrfn.DefaultStderr, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "reduce:", err)
+		os.Exit(1)
+	}
+	fmt.Println("sum:", sum)
+
+	// Compute 1.5-norm.
+	// Demonstrates reduce function with a parameter.
+	var norm float64
+	if err := dstrfn.Reduce("norm", &norm, x, dstrfn.Args(1.5), dstrfn.DefaultStdout, dstrfn.DefaultStderr, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "reduce:", err)
+		os.Exit(1)
+	}
+	fmt.Println("1.5-norm:", norm)
 
 	// Compute 2-norm of each vector.
 	var norms2 []float64
@@ -125,11 +125,11 @@ func main() {
 	}
 	fmt.Println("norms1:", norms1)
 
-	//	// Compute sum of all vectors.
-	//	var vecsum *Vec
-	//	if err := dstrfn.Reduce("vec-add", &vecsum, vecs, nil); err != nil {
-	//		fmt.Fprintln(os.Stderr, "map:", err)
-	//		os.Exit(1)
-	//	}
-	//	fmt.Println("vecsum:", vecsum)
+	// Compute sum of all vectors.
+	var vecsum *Vec
+	if err := dstrfn.Reduce("vec-add", &vecsum, vecs, nil, dstrfn.DefaultStdout, dstrfn.DefaultStderr, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "reduce:", err)
+		os.Exit(1)
+	}
+	fmt.Println("vecsum:", vecsum)
 }
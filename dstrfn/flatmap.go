@@ -0,0 +1,88 @@
+package dstrfn
+
+import (
+	"context"
+	"io"
+	"reflect"
+)
+
+// FlatMap computes f(x[i], p) for all i and concatenates the outputs in order.
+//
+// The task must be registered using RegisterMap and return a slice []Y.
+// The output y must be a pointer to a slice []Y.
+// Unlike Map, the length of y need not match that of x.
+//
+// If some elements fail, the error is a MapError whose indices refer to x,
+// and y contains the concatenated outputs of the other elements.
+func FlatMap(f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	return FlatMapContext(context.Background(), f, y, x, p, stdout, stderr, flags)
+}
+
+// FlatMapContext is like FlatMap but cancels the jobs if the context is done first.
+func FlatMapContext(ctx context.Context, f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	// Map to a slice of slices.
+	ytyp := reflect.TypeOf(y).Elem()
+	z := reflect.New(reflect.SliceOf(ytyp))
+	err := MapContext(ctx, f, z.Interface(), x, p, stdout, stderr, flags)
+	failed, err := mapFailures(err)
+	if failed == nil {
+		return err
+	}
+
+	out := reflect.MakeSlice(ytyp, 0, 0)
+	for i := 0; i < z.Elem().Len(); i++ {
+		if failed(i) {
+			continue
+		}
+		out = reflect.AppendSlice(out, z.Elem().Index(i))
+	}
+	reflect.ValueOf(y).Elem().Set(out)
+	return err
+}
+
+// Filter keeps the elements of x for which f(x[i], p) is true.
+//
+// The task must be registered using RegisterMap and return a bool.
+// The output y must be a pointer to a slice of the same type as x.
+// The order of the elements is preserved.
+//
+// If some elements fail, the error is a MapError whose indices refer to x,
+// and y contains the elements which were kept among the others.
+func Filter(f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	return FilterContext(context.Background(), f, y, x, p, stdout, stderr, flags)
+}
+
+// FilterContext is like Filter but cancels the jobs if the context is done first.
+func FilterContext(ctx context.Context, f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	var keep []bool
+	err := MapContext(ctx, f, &keep, x, p, stdout, stderr, flags)
+	failed, err := mapFailures(err)
+	if failed == nil {
+		return err
+	}
+
+	xval := reflect.ValueOf(x)
+	out := reflect.MakeSlice(reflect.TypeOf(y).Elem(), 0, 0)
+	for i := range keep {
+		if failed(i) || !keep[i] {
+			continue
+		}
+		out = reflect.Append(out, xval.Index(i))
+	}
+	reflect.ValueOf(y).Elem().Set(out)
+	return err
+}
+
+// Inspects the error returned by Map.
+// Returns a function which reports whether element i failed,
+// or nil if no outputs were loaded.
+func mapFailures(err error) (func(i int) bool, error) {
+	if err == nil {
+		return func(int) bool { return false }, nil
+	}
+	mapErr, ok := err.(MapError)
+	if !ok {
+		return nil, err
+	}
+	return func(i int) bool { return mapErr.Tasks[i] != nil }, err
+}
@@ -0,0 +1,131 @@
+package dstrfn
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// Job refers to a Call or Map which has been submitted.
+//
+// The outputs are not available until Wait has returned.
+// A Job may submit further jobs before it finishes,
+// for example to retry the elements of a map which failed.
+type Job struct {
+	mu     sync.Mutex
+	handle Handle
+	// Closed by Cancel.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// Temporary directory containing inputs and outputs.
+	dir string
+	// Keep the directory after success?
+	keep bool
+	// Called once the current jobs have finished.
+	// The argument is the error returned by Handle.Wait().
+	// Returns a handle if further jobs were submitted.
+	// Otherwise loads the outputs and returns nil.
+	next func(execErr error) (Handle, error)
+	// Calls emit for each element of a map whose output is available
+	// and for which seen returns false.
+	// Nil if the job is not a map.
+	poll func(seen func(i int) bool, emit func(i int, y reflect.Value))
+}
+
+func newJob(handle Handle, dir string) *Job {
+	return &Job{handle: handle, dir: dir, stop: make(chan struct{})}
+}
+
+// Returns the jobs which were most recently submitted.
+func (j *Job) current() Handle {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.handle
+}
+
+// Returns the identifier assigned to the job by the scheduler.
+// For example, the PBS job ID printed by qsub.
+func (j *Job) ID() string {
+	return j.current().ID()
+}
+
+// Returns the number of jobs in each state.
+// For a chunked map, each job contains several elements.
+func (j *Job) Status() (Status, error) {
+	return j.current().Status()
+}
+
+// Removes the jobs from the scheduler.
+// No further jobs will be submitted.
+// Wait must still be called.
+func (j *Job) Cancel() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stopOnce.Do(func() { close(j.stop) })
+	return j.handle.Cancel()
+}
+
+// Returns true if Cancel has been called.
+func (j *Job) stopped() bool {
+	select {
+	case <-j.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Blocks until all jobs have finished and then loads the outputs.
+// Must be called exactly once.
+func (j *Job) Wait() error {
+	return j.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait but cancels the jobs if the context is done first.
+// The outputs of the jobs which had already finished are still loaded.
+// For a map, the error is then a MapError whose Master is ctx.Err().
+// For a call, the error is ctx.Err().
+func (j *Job) WaitContext(ctx context.Context) error {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := j.Cancel(); err != nil {
+				log.Println("cancel:", err)
+			}
+		case <-finished:
+		}
+	}()
+
+	for {
+		execErr := j.current().Wait()
+		if err := ctx.Err(); err != nil {
+			execErr = err
+		}
+		handle, err := j.next(execErr)
+		if err != nil {
+			return err
+		}
+		if handle == nil {
+			break
+		}
+		j.mu.Lock()
+		j.handle = handle
+		j.mu.Unlock()
+		// Cancel may have been called before the handle was replaced.
+		if j.stopped() {
+			if err := handle.Cancel(); err != nil {
+				log.Println("cancel:", err)
+			}
+		}
+	}
+	// Only remove temporary directory if there was no error.
+	if !debug && !j.keep {
+		if err := removeAll(j.dir); err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package dstrfn
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"sync"
+)
+
+var localProcs int
+
+func init() {
+	flag.IntVar(&localProcs, "dstrfn.local-procs", runtime.NumCPU(), "Maximum number of worker processes to run at once with -dstrfn.backend=local.")
+	RegisterScheduler("local", localScheduler{})
+}
+
+// Executes jobs as subprocesses on the local machine.
+//
+// Each job re-executes the program with the same arguments
+// that would be given to a job in the batch system.
+// The environment variables PBS_O_WORKDIR and PBS_ARRAY_INDEX are set
+// so that the worker behaves as it would under PBS.
+type localScheduler struct{}
+
+func (localScheduler) Submit(spec *JobSpec) (Handle, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	job := &localJob{
+		n:      spec.Len,
+		procs:  make(map[int]*os.Process),
+		cancel: make(chan struct{}),
+		exit:   make(chan error, 1),
+	}
+	go func() {
+		job.exit <- job.run(spec, wd)
+	}()
+	return job, nil
+}
+
+// The worker is given the same environment as under PBS.
+func (localScheduler) WorkDir() (string, error) {
+	return pbsScheduler{}.WorkDir()
+}
+
+func (localScheduler) ArrayIndex() (int, error) {
+	return pbsScheduler{}.ArrayIndex()
+}
+
+// Array of jobs running as subprocesses.
+type localJob struct {
+	n        int
+	mu       sync.Mutex
+	procs    map[int]*os.Process
+	done     int
+	failed   int
+	canceled bool
+	cancel   chan struct{}
+	exit     chan error
+}
+
+// Runs every job, at most -dstrfn.local-procs at a time.
+// Returns the first error encountered.
+func (j *localJob) run(spec *JobSpec, wd string) error {
+	sem := make(chan struct{}, max(localProcs, 1))
+	errs := make(chan error, spec.Len)
+	var wg sync.WaitGroup
+	for i := 0; i < spec.Len; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-j.cancel:
+		}
+		if j.isCanceled() {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := j.exec(spec, wd, i); err != nil {
+				errs <- fmt.Errorf("job %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if j.isCanceled() {
+		return fmt.Errorf("canceled")
+	}
+	for err := range errs {
+		return err
+	}
+	return nil
+}
+
+// Executes job i and waits for it to exit.
+func (j *localJob) exec(spec *JobSpec, wd string, i int) error {
+	// Mimic the file names and one-indexing of PBS.
+	index := i + 1
+	stdout, err := os.Create(path.Join(spec.Dir, fmt.Sprintf("%s.o%d", spec.Name, index)))
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(path.Join(spec.Dir, fmt.Sprintf("%s.e%d", spec.Name, index)))
+	if err != nil {
+		return err
+	}
+	defer stderr.Close()
+
+	cmd := exec.Command(spec.Path, spec.Args...)
+	cmd.Dir = wd
+	cmd.Env = append(os.Environ(),
+		"PBS_O_WORKDIR="+wd,
+		fmt.Sprintf("PBS_ARRAY_INDEX=%d", index),
+	)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	j.mu.Lock()
+	if j.canceled {
+		j.mu.Unlock()
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		j.mu.Unlock()
+		return err
+	}
+	j.procs[i] = cmd.Process
+	j.mu.Unlock()
+
+	err = cmd.Wait()
+	j.mu.Lock()
+	delete(j.procs, i)
+	if err != nil {
+		j.failed++
+	} else {
+		j.done++
+	}
+	j.mu.Unlock()
+	return err
+}
+
+func (j *localJob) isCanceled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.canceled
+}
+
+// Local jobs do not have an identifier.
+func (j *localJob) ID() string {
+	return ""
+}
+
+func (j *localJob) Status() (Status, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	running := len(j.procs)
+	return Status{
+		Queued:  j.n - running - j.done - j.failed,
+		Running: running,
+		Done:    j.done,
+		Failed:  j.failed,
+	}, nil
+}
+
+func (j *localJob) Wait() error {
+	return <-j.exit
+}
+
+// Kills all running processes and does not start any more.
+func (j *localJob) Cancel() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.canceled {
+		return nil
+	}
+	j.canceled = true
+	close(j.cancel)
+	for _, proc := range j.procs {
+		proc.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,619 @@
+package dstrfn
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	scaleTask  *MapTask[float64, float64, float64]
+	offsetTask *MapTask[float64, *float64, float64]
+	lengthTask *CallTask[string, int]
+)
+
+// The test binary is re-executed as a worker by the local scheduler.
+func TestMain(m *testing.M) {
+	Register("add-three", Func(func(x, y, z float64) float64 { return x + y + z }))
+	RegisterMap("square", false, Func(func(x float64) float64 { return x * x }))
+	RegisterMap("add-const", true, ConfigFunc(func(x, y float64) float64 { return x + y }))
+	RegisterMap("sqrt", false, Func(func(x float64) (float64, error) {
+		if x < 0 {
+			return 0, errors.New("negative")
+		}
+		return x, nil
+	}))
+	RegisterMap("sleep", false, Func(func(x float64) float64 {
+		time.Sleep(time.Duration(x * float64(time.Second)))
+		return x
+	}))
+	// Fails the first time it is called for each x and always fails for negative x.
+	RegisterMap("flaky", false, Func(func(x float64) (float64, error) {
+		marker := fmt.Sprintf("flaky-%v", x)
+		if _, err := os.Stat(marker); os.IsNotExist(err) {
+			if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+				return 0, err
+			}
+			return 0, errors.New("first attempt")
+		}
+		if x < 0 {
+			return 0, errors.New("negative")
+		}
+		return x, nil
+	}), Retry(3, 0))
+	RegisterReduce("sum", true, ReduceFunc(func(x, y float64) float64 { return x + y }))
+	RegisterMap("parity", false, Func(func(x int) map[string]int {
+		if x%2 == 0 {
+			return map[string]int{"even": x}
+		}
+		return map[string]int{"odd": x}
+	}))
+	for _, enc := range []string{"gob", "msgpack", "binary"} {
+		RegisterMap("recip-"+enc, true, Func(func(x float64) float64 { return 1 / x }), Encoding(enc))
+	}
+	for _, comp := range []string{"gzip", "zstd"} {
+		RegisterMap("recip-"+comp, false, Func(func(x float64) float64 { return 1 / x }), Encoding("msgpack"), Compress(comp))
+	}
+	RegisterMap("range", true, Func(func(n int) ([]int, error) {
+		if n < 0 {
+			return nil, errors.New("negative")
+		}
+		y := make([]int, n)
+		for i := range y {
+			y[i] = i
+		}
+		return y, nil
+	}))
+	RegisterMap("add-const-pack", true, ConfigFunc(func(x, y float64) float64 { return x + y }), Pack(true))
+	RegisterMap("sqrt-pack", false, Func(func(x float64) (float64, error) {
+		if x < 0 {
+			return 0, errors.New("negative")
+		}
+		return math.Sqrt(x), nil
+	}), Pack(true), Compress("gzip"))
+	RegisterMap("panic-neg", false, Func(func(x float64) float64 {
+		if x < 0 {
+			panic("negative input")
+		}
+		return x
+	}))
+	RegisterMap("is-odd", true, Func(func(x int) bool { return x%2 != 0 }))
+	RegisterReduce("sum-int", false, ReduceFunc(func(x, y int) int { return x + y }))
+	RegisterReduce("weighted-sum", false, ReduceFunc(func(x, y, w float64) float64 { return x + w*y }))
+	scaleTask = NewMapTask("scale", func(x, a float64) (float64, error) { return a * x, nil }, Chunk(true))
+	offsetTask = NewMapTask("offset", func(x float64, a *float64) (float64, error) {
+		if a == nil {
+			return x, nil
+		}
+		return x + *a, nil
+	})
+	lengthTask = NewTask("length", func(s string) (int, error) { return len(s), nil })
+	flag.Parse()
+	ExecIfSlave()
+
+	// Keep temporary directories out of the source tree.
+	dir, err := ioutil.TempDir("", "dstrfn-test-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	backend = "local"
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestCall_Local(t *testing.T) {
+	var y float64
+	if err := CallFunc("add-three", &y, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if y != 6 {
+		t.Errorf("expect 6, got %v", y)
+	}
+}
+
+func TestMap_Local(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5}
+	var y []float64
+	if err := MapFunc("square", &y, x); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 4, 9, 16, 25}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMap_LocalChunk(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5}
+	var y []float64
+	if err := MapFunc("add-const", &y, x, 10); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{11, 12, 13, 14, 15}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMap_LocalTaskError(t *testing.T) {
+	x := []float64{1, -2, 3}
+	var y []float64
+	err := MapFunc("sqrt", &y, x)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 1 || mapErr.Tasks[1] == nil {
+		t.Errorf("expect error for task 1 only, got %v", mapErr.Tasks)
+	}
+}
+
+func TestMap_LocalPanic(t *testing.T) {
+	x := []float64{1, -2, 3}
+	var y []float64
+	err := MapFunc("panic-neg", &y, x)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 1 || mapErr.Tasks[1] == nil {
+		t.Fatalf("expect error for task 1 only, got %v", mapErr.Tasks)
+	}
+	msg := mapErr.Tasks[1].Error()
+	if !strings.Contains(msg, "panic: negative input") || !strings.Contains(msg, "goroutine") {
+		t.Errorf("expect panic value and stack, got %q", msg)
+	}
+}
+
+func TestMap_LocalTaskErrorRecord(t *testing.T) {
+	x := []float64{1, -2, 3}
+	var y []float64
+	err := MapFunc("sqrt", &y, x)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	var taskErr *TaskError
+	if !errors.As(mapErr.Tasks[1], &taskErr) {
+		t.Fatalf("expect *TaskError, got %T", mapErr.Tasks[1])
+	}
+	host, _ := os.Hostname()
+	if taskErr.Msg != "negative" || taskErr.Type != "*errors.errorString" || taskErr.Host != host {
+		t.Errorf(`expect ("negative", "*errors.errorString", "%s"), got (%q, %q, %q)`, host, taskErr.Msg, taskErr.Type, taskErr.Host)
+	}
+	if taskErr.Attempt != 1 {
+		t.Errorf("expect attempt 1, got %d", taskErr.Attempt)
+	}
+}
+
+func TestMapAsync_Local(t *testing.T) {
+	x := []float64{1, 2, 3}
+	var y []float64
+	job, err := MapAsync("square", &y, x, nil, DefaultStdout, DefaultStderr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := job.Status(); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 4, 9}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMapContext_LocalCancel(t *testing.T) {
+	x := []float64{0, 60}
+	var y []float64
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := MapContext(ctx, "sleep", &y, x, nil, DefaultStdout, DefaultStderr, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	mapErr := err.(MapError)
+	if mapErr.Tasks[0] != nil || mapErr.Tasks[1] == nil {
+		t.Errorf("expect error for task 1 only, got %v", mapErr.Tasks)
+	}
+}
+
+func TestMap_LocalRetry(t *testing.T) {
+	x := []float64{1, -2, 3}
+	var y []float64
+	err := MapFunc("flaky", &y, x)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 1 || mapErr.Tasks[1] == nil {
+		t.Errorf("expect error for task 1 only, got %v", mapErr.Tasks)
+	}
+	if mapErr.Attempts[1] != 3 {
+		t.Errorf("expect 3 attempts, got %d", mapErr.Attempts[1])
+	}
+	var taskErr *TaskError
+	if !errors.As(mapErr.Tasks[1], &taskErr) {
+		t.Fatalf("expect *TaskError, got %T", mapErr.Tasks[1])
+	}
+	if taskErr.Attempt != 3 {
+		t.Errorf("expect error from attempt 3, got %d", taskErr.Attempt)
+	}
+	if y[0] != 1 || y[2] != 3 {
+		t.Errorf("expect outputs of successful retries, got %v", y)
+	}
+}
+
+func TestMap_LocalResume(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "resume-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumeDir = dir
+	defer func() { resumeDir = "" }()
+
+	x := []float64{1, 2, 3}
+	var y []float64
+	if err := MapFunc("square", &y, x); err != nil {
+		t.Fatal(err)
+	}
+	// Modify an output to check that it is not computed again.
+	run := &mapRun{Dir: path.Join(dir, "square-0"), Ext: "json"}
+	if err := saveFile(run.outFile(1), 100.0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(run.outFile(2)); err != nil {
+		t.Fatal(err)
+	}
+	resumeSeq = make(map[string]int)
+	y = nil
+	if err := MapFunc("square", &y, x); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 100, 9}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+
+	// Cannot resume with a different length.
+	resumeSeq = make(map[string]int)
+	if err := MapFunc("square", &y, x[:2]); err == nil {
+		t.Error("expect error for different length")
+	}
+	// Cannot resume with different inputs of the same length.
+	resumeSeq = make(map[string]int)
+	if err := MapFunc("square", &y, []float64{4, 5, 6}); err == nil {
+		t.Error("expect error for different inputs")
+	}
+}
+
+func TestMap_LocalCache(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir = dir
+	defer func() { cacheDir = "" }()
+
+	var y []float64
+	if err := MapFunc("square", &y, []float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	// Modify a cached output to check that it is not computed again.
+	key, err := cacheKey("square", "", "json", 2.0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheSave("square", key, "json", 100.0); err != nil {
+		t.Fatal(err)
+	}
+	y = nil
+	if err := MapFunc("square", &y, []float64{2, 4}); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{100, 16}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMap_LocalResumeCache(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "resume-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumeDir = dir
+	defer func() { resumeDir = "" }()
+	defer func() { cacheDir = "" }()
+
+	maps := func() ([]float64, error) {
+		var y []float64
+		// The second map is found entirely in the cache.
+		for _, x := range [][]float64{{1, 2}, {1, 2}, {3, 4}} {
+			y = nil
+			if err := MapFunc("square", &y, x); err != nil {
+				return nil, err
+			}
+		}
+		return y, nil
+	}
+	resumeSeq = make(map[string]int)
+	if cacheDir, err = ioutil.TempDir(".", "cache-"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := maps(); err != nil {
+		t.Fatal(err)
+	}
+	// Modify an output of the last map to check that it is resumed.
+	run := &mapRun{Dir: path.Join(dir, "square-2"), Ext: "json"}
+	if err := saveFile(run.outFile(0), 100.0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resume with an empty cache.
+	resumeSeq = make(map[string]int)
+	if cacheDir, err = ioutil.TempDir(".", "cache-"); err != nil {
+		t.Fatal(err)
+	}
+	y, err := maps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{100, 16}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestReduce_Local(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5}
+	var y float64
+	if err := Reduce("sum", &y, x, nil, DefaultStdout, DefaultStderr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if y != 15 {
+		t.Errorf("expect 15, got %v", y)
+	}
+	// ((1 + 2w) + (3 + 4w)w) + 5w
+	if err := Reduce("weighted-sum", &y, x, Args(2.0), DefaultStdout, DefaultStderr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := 5.0 + 11*2 + 5*2; y != want {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestReduce_LocalArity(t *testing.T) {
+	spec := reduceTasks["weighted-sum"]
+	spec.Arity = 3
+	defer func() { spec.Arity = 2 }()
+
+	x := []float64{1, 2, 3, 4, 5}
+	var y float64
+	if err := Reduce("weighted-sum", &y, x, Args(2.0), DefaultStdout, DefaultStderr, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Groups {1, 2, 3} and {4, 5} give 11 and 14.
+	if want := 11.0 + 2*14; y != want {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMapReduce_Local(t *testing.T) {
+	for _, partitions := range []int{0, 1, 3} {
+		reduceTasks["sum-int"].Partitions = partitions
+		var y map[string]int
+		err := MapReduce("parity", "sum-int", &y, []int{1, 2, 3, 4, 5}, nil, DefaultStdout, DefaultStderr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]int{"odd": 9, "even": 6}
+		if !reflect.DeepEqual(want, y) {
+			t.Errorf("partitions %d: expect %v, got %v", partitions, want, y)
+		}
+	}
+	reduceTasks["sum-int"].Partitions = 0
+}
+
+func TestFlatMap_Local(t *testing.T) {
+	var y []int
+	err := FlatMap("range", &y, []int{2, -1, 0, 3}, nil, DefaultStdout, DefaultStderr, nil)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 1 || mapErr.Tasks[1] == nil {
+		t.Errorf("expect error for task 1 only, got %v", mapErr.Tasks)
+	}
+	want := []int{0, 1, 0, 1, 2}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestFilter_Local(t *testing.T) {
+	var y []int
+	if err := Filter("is-odd", &y, []int{1, 2, 3, 4, 5}, nil, DefaultStdout, DefaultStderr, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{1, 3, 5}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMapStream_Local(t *testing.T) {
+	results, err := MapStream("sqrt", []float64{4, -1, 9}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int]Result)
+	for r := range results {
+		if _, dup := got[r.Index]; dup {
+			t.Errorf("element %d sent twice", r.Index)
+		}
+		got[r.Index] = r
+	}
+	if len(got) != 3 {
+		t.Fatalf("expect 3 results, got %d", len(got))
+	}
+	if got[0].Err != nil || got[0].Value != 4.0 || got[2].Value != 9.0 {
+		t.Errorf("expect outputs of elements 0 and 2, got %v", got)
+	}
+	if got[1].Err == nil {
+		t.Errorf("expect error for element 1")
+	}
+}
+
+func TestMapTask_Local(t *testing.T) {
+	y, err := scaleTask.Map(context.Background(), []float64{1, 2, 3}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{10, 20, 30}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestMapTask_LocalNilConfig(t *testing.T) {
+	x := []float64{1, 2, 3}
+	y, err := offsetTask.Map(context.Background(), x, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x, y) {
+		t.Errorf("nil: expect %v, got %v", x, y)
+	}
+	a := 10.0
+	y, err = offsetTask.Map(context.Background(), x, &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{11, 12, 13}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+}
+
+func TestCallTask_Local(t *testing.T) {
+	y, err := lengthTask.Call(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y != 5 {
+		t.Errorf("expect 5, got %v", y)
+	}
+}
+
+func TestMap_LocalEncoding(t *testing.T) {
+	x := []float64{0, 2, math.Inf(1)}
+	want := []float64{math.Inf(1), 0.5, 0}
+	for _, enc := range []string{"gob", "msgpack", "binary"} {
+		var y []float64
+		if err := MapFunc("recip-"+enc, &y, x); err != nil {
+			t.Errorf("%s: %v", enc, err)
+			continue
+		}
+		if !reflect.DeepEqual(want, y) {
+			t.Errorf("%s: expect %v, got %v", enc, want, y)
+		}
+	}
+}
+
+func TestMap_LocalCompress(t *testing.T) {
+	x := []float64{0, 2, 4}
+	want := []float64{math.Inf(1), 0.5, 0.25}
+	for _, comp := range []string{"gzip", "zstd"} {
+		var y []float64
+		if err := MapFunc("recip-"+comp, &y, x); err != nil {
+			t.Errorf("%s: %v", comp, err)
+			continue
+		}
+		if !reflect.DeepEqual(want, y) {
+			t.Errorf("%s: expect %v, got %v", comp, want, y)
+		}
+	}
+}
+
+func TestMap_LocalPack(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "pack-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumeDir = dir
+	defer func() { resumeDir = "" }()
+	mapTasks["add-const-pack"].ChunkLen = 2
+	defer func() { mapTasks["add-const-pack"].ChunkLen = 1 }()
+
+	x := []float64{1, 2, 3, 4, 5}
+	var y []float64
+	if err := MapFunc("add-const-pack", &y, x, 10); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{11, 12, 13, 14, 15}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("expect %v, got %v", want, y)
+	}
+	// Segments are merged into a single output file.
+	files, err := ioutil.ReadDir(path.Join(dir, "add-const-pack-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, file := range files {
+		names[file.Name()] = true
+	}
+	for _, name := range []string{"in.json", "in.index", "out.json", "out.index"} {
+		if !names[name] {
+			t.Errorf("expect file %s", name)
+		}
+	}
+	for name := range names {
+		if strings.HasPrefix(name, "in-") || strings.HasPrefix(name, "out-") || strings.HasPrefix(name, "seg-") {
+			t.Errorf("expect no file %s", name)
+		}
+	}
+
+	// Resume from the packed outputs.
+	resumeSeq = make(map[string]int)
+	y = nil
+	if err := MapFunc("add-const-pack", &y, x, 10); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, y) {
+		t.Errorf("resume: expect %v, got %v", want, y)
+	}
+}
+
+func TestMap_LocalPackError(t *testing.T) {
+	x := []float64{4, -1, 9}
+	var y []float64
+	err := MapFunc("sqrt-pack", &y, x)
+	mapErr, ok := err.(MapError)
+	if !ok {
+		t.Fatalf("expect MapError, got %v", err)
+	}
+	if len(mapErr.Tasks) != 1 || mapErr.Tasks[1] == nil {
+		t.Errorf("expect error for task 1 only, got %v", mapErr.Tasks)
+	}
+	if y[0] != 2 || y[2] != 3 {
+		t.Errorf("expect outputs of other elements, got %v", y)
+	}
+}
@@ -1,16 +1,12 @@
 package dstrfn
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"os"
-	"path"
 	"reflect"
-
-	"github.com/jvlmdr/go-file/fileutil"
+	"time"
 )
 
 func MapFunc(f string, y, x interface{}, p ...interface{}) error {
@@ -26,145 +22,263 @@ func MapFunc(f string, y, x interface{}, p ...interface{}) error {
 // If it is not sufficient, a new array will be allocated.
 // After a succesful call, the length of y will match that of x.
 func Map(f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	return MapContext(context.Background(), f, y, x, p, stdout, stderr, flags)
+}
+
+// MapContext is like Map but cancels the jobs if the context is done first.
+// The error is then a MapError whose Master is ctx.Err()
+// and whose Tasks include every element which did not finish.
+// The outputs of the elements which did finish are assigned to y.
+func MapContext(ctx context.Context, f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	job, err := MapAsync(f, y, x, p, stdout, stderr, flags)
+	if err != nil {
+		return err
+	}
+	return job.WaitContext(ctx)
+}
+
+// MapAsync submits the jobs for Map and returns without waiting for them.
+// The outputs are loaded into y by Job.Wait(),
+// which returns the same errors as Map.
+func MapAsync(f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) (*Job, error) {
 	task, there := mapTasks[f]
 	if !there {
-		return fmt.Errorf(`map task not found: "%s"`, f)
-	}
-
-	// Recursively invoked closure.
-	var do func(task *mapTaskSpec, y, x interface{}, chunk bool) (string, error)
-	do = func(task *mapTaskSpec, y, x interface{}, chunk bool) (string, error) {
-		n := reflect.ValueOf(x).Len()
-		y = ensureLenAndDeref(y, n)
-		// y now has correct len, is not a pointer, and can be modified.
-
-		if chunk {
-			u, inds := split(x, 1, max(task.ChunkLen, 1))
-			// Create slice of slices for output.
-			vtyp := reflect.SliceOf(reflect.TypeOf(y))
-			v := reflect.New(vtyp).Interface()
-			dir, err := do(task, v, u, false)
-			v = deref(v)
-			if err != nil {
-				mapErr := err.(MapError)
-				// Need to re-map task errors.
-				taskErrs := make(map[int]error)
-				for i := range inds {
-					if err := mapErr.Tasks[i]; err != nil {
-						// Give error to all members.
-						for _, p := range inds[i] {
-							taskErrs[p] = err
-						}
-						continue
-					}
-					// No error occured. Move outputs.
-					for j, p := range inds[i] {
-						vij := reflect.ValueOf(v).Index(i).Index(j)
-						yp := reflect.ValueOf(y).Index(p)
-						yp.Set(vij)
-					}
-				}
-				return dir, MapError{mapErr.Master, taskErrs, n}
-			}
+		return nil, fmt.Errorf(`map task not found: "%s"`, f)
+	}
+	return submitMap(task, f, y, x, p, flags)
+}
+
+// Submits the jobs for a map of any registered task.
+func submitMap(task *mapTaskSpec, f string, y, x, p interface{}, flags []string) (*Job, error) {
+	if len(cacheDir) > 0 {
+		return mapAsyncCache(task, f, y, x, p, flags)
+	}
+	return mapAsyncChunk(task, f, y, x, p, flags)
+}
+
+// Splits x into chunks if the task is chunked
+// and then submits one job per chunk using mapAsync.
+// A packed map is not split since each of its jobs performs a group of elements.
+func mapAsyncChunk(task *mapTaskSpec, f string, y, x, p interface{}, flags []string) (*Job, error) {
+	if !task.Chunk || task.Pack {
+		return mapAsync(task, f, y, x, p, flags)
+	}
+
+	n := reflect.ValueOf(x).Len()
+	y = ensureLenAndDeref(y, n)
+	// y now has correct len, is not a pointer, and can be modified.
+	u, inds := split(x, 1, max(task.ChunkLen, 1))
+	// Create slice of slices for output.
+	vtyp := reflect.SliceOf(reflect.TypeOf(y))
+	v := reflect.New(vtyp).Interface()
+	job, err := mapAsync(task, f, v, u, p, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	next := job.next
+	job.next = func(execErr error) (Handle, error) {
+		handle, err := next(execErr)
+		if handle != nil {
+			return handle, nil
+		}
+		v := deref(v)
+		if err == nil {
 			mergeTo(y, v)
-			return dir, nil
+			return nil, nil
 		}
-
-		// Create temporary directory.
-		wd, err := os.Getwd()
-		if err != nil {
-			return "", err
+		mapErr, ok := err.(MapError)
+		if !ok {
+			return nil, err
 		}
-		dir, err := ioutil.TempDir(wd, f+"-")
-		if err != nil {
-			return "", err
+		// Need to re-map task errors.
+		taskErrs := make(map[int]error)
+		attempts := make(map[int]int)
+		for i := range inds {
+			if err := mapErr.Tasks[i]; err != nil {
+				// Give error to all members.
+				for _, p := range inds[i] {
+					taskErrs[p] = err
+					attempts[p] = mapErr.Attempts[i]
+				}
+				continue
+			}
+			// No error occured. Move outputs.
+			for j, p := range inds[i] {
+				vij := reflect.ValueOf(v).Index(i).Index(j)
+				yp := reflect.ValueOf(y).Index(p)
+				yp.Set(vij)
+			}
 		}
+		return nil, MapError{mapErr.Master, taskErrs, n, attempts}
+	}
 
-		// Save each input to file.
-		xval := reflect.ValueOf(x)
-		for i := 0; i < xval.Len(); i++ {
-			inFile := path.Join(dir, fmt.Sprintf("in-%d.json", i))
-			err := fileutil.SaveExt(inFile, xval.Index(i).Interface())
-			if err != nil {
-				return dir, fmt.Errorf("save input %d: %v", i, err)
+	poll := job.poll
+	job.poll = func(seen func(int) bool, emit func(int, reflect.Value)) {
+		chunkSeen := func(i int) bool {
+			for _, p := range inds[i] {
+				if !seen(p) {
+					return false
+				}
 			}
+			return true
 		}
-		if p != nil {
-			confFile := path.Join(dir, "conf.json")
-			err := fileutil.SaveExt(confFile, p)
-			if err != nil {
-				return dir, fmt.Errorf("save config: %v", err)
+		poll(chunkSeen, func(i int, vi reflect.Value) {
+			for j, p := range inds[i] {
+				emit(p, vi.Index(j))
 			}
+		})
+	}
+	return job, nil
+}
+
+// Saves the inputs and submits one job per element of x.
+// Does not consider chunking.
+//
+// Elements which fail are submitted again
+// until they have been attempted task.Attempts times.
+func mapAsync(task *mapTaskSpec, f string, y, x, p interface{}, flags []string) (*Job, error) {
+	n := reflect.ValueOf(x).Len()
+	y = ensureLenAndDeref(y, n)
+	// y now has correct len, is not a pointer, and can be modified.
+
+	dir, persist, err := mapDir(f)
+	if err != nil {
+		return nil, err
+	}
+	run, err := newMapRun(task, f, dir, n, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Elements in the current array, nil for all.
+	var inds []int
+	if persist {
+		// Load any outputs which already exist.
+		inds, err = run.resume(y, x, p)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		// Invoke qsub.
-		jobargs := []string{"-dstrfn.task", f, "-dstrfn.map", fmt.Sprint(n), "-dstrfn.dir", dir}
-		if len(flags) > 0 {
-			jobargs = append(jobargs, flags...)
+	// Save inputs to file.
+	if err := run.saveInputs(x, inds); err != nil {
+		return nil, err
+	}
+	if !isNil(p) {
+		err := saveFile(run.confFile(), p)
+		if err != nil {
+			return nil, fmt.Errorf("save config: %v", err)
 		}
-		execErr, err := submit(n, jobargs, f, dir, task.Flags, nil, nil)
+	}
+
+	// Submit jobs.
+	var handle Handle
+	if inds != nil && len(inds) == 0 {
+		// All outputs were loaded from a previous run.
+		handle = finishedHandle{}
+	} else {
+		handle, err = run.submit(inds)
 		if err != nil {
-			return dir, err
+			return nil, err
 		}
+	}
+	job := newJob(handle, dir)
+	job.keep = persist
 
+	attempts := make(map[int]int)
+	job.next = func(execErr error) (Handle, error) {
+		if err := run.merge(); err != nil {
+			return nil, err
+		}
 		taskErrs := make(map[int]error)
-		for i := 0; i < n; i++ {
-			// Load from output file.
-			outFile := path.Join(dir, fmt.Sprintf("out-%d.json", i))
-			errFile := path.Join(dir, fmt.Sprintf("err-%d.json", i))
+		load := func(i int) {
+			attempts[i]++
 			yi := reflect.ValueOf(y).Index(i).Addr().Interface()
-
-			if _, err := os.Stat(outFile); err == nil {
-				// If output file exists, attempt to load.
-				if err := fileutil.LoadExt(outFile, yi); err != nil {
-					taskErrs[i] = fmt.Errorf("load output: %v", err)
-					continue
+			if err := run.load(i, yi); err != nil {
+				if taskErr, ok := err.(*TaskError); ok {
+					taskErr.Attempt = attempts[i]
 				}
-			} else if !os.IsNotExist(err) {
-				// Could not stat file.
 				taskErrs[i] = err
-				continue
-			} else {
-				// Output file did not exist. Try to load error file.
-				if _, err := os.Stat(errFile); err == nil {
-					// Error file exists. Attempt to load.
-					var str string
-					if err := fileutil.LoadExt(errFile, &str); err != nil {
-						taskErrs[i] = err
-						continue
+			}
+		}
+		if inds == nil {
+			for i := 0; i < n; i++ {
+				load(i)
+			}
+		} else {
+			for _, i := range inds {
+				load(i)
+			}
+		}
+		if len(taskErrs) == 0 {
+			if execErr != nil {
+				return nil, MapError{execErr, nil, n, nil}
+			}
+			return nil, nil
+		}
+
+		// All failed elements have been attempted the same number of times.
+		k := keys(taskErrs)
+		if attempts[k[0]] < task.Attempts && !job.stopped() {
+			if execErr != nil {
+				log.Println("retry after error:", execErr)
+			}
+			// Back off exponentially.
+			delay := task.Backoff << uint(attempts[k[0]]-1)
+			log.Printf("retry %d failed elements in %v", len(k), delay)
+			select {
+			case <-time.After(delay):
+			case <-job.stop:
+			}
+			if !job.stopped() {
+				for _, i := range k {
+					if err := run.clear(i); err != nil {
+						return nil, err
 					}
-					taskErrs[i] = errors.New(str)
-					continue
-				} else if !os.IsNotExist(err) {
-					// Could not stat file.
-					taskErrs[i] = err
-					continue
 				}
-				taskErrs[i] = fmt.Errorf("could not find output or error files: job %d", i)
-				continue
+				handle, err := run.submit(k)
+				if err != nil {
+					return nil, err
+				}
+				inds = k
+				return handle, nil
 			}
 		}
 
-		if execErr != nil {
-			return dir, MapError{execErr, taskErrs, n}
+		failed := make(map[int]int)
+		for _, i := range k {
+			failed[i] = attempts[i]
 		}
-		if len(taskErrs) > 0 {
-			return dir, MapError{Tasks: taskErrs, Len: n}
-		}
-		return dir, nil
+		return nil, MapError{execErr, taskErrs, n, failed}
 	}
 
-	tmpdir, err := do(task, y, x, task.Chunk)
-	if err != nil {
-		return err
-	}
-	// Only remove temporary directory if there was no error.
-	if !debug {
-		if err := removeAll(tmpdir); err != nil {
-			log.Println(err)
+	etyp := reflect.TypeOf(y).Elem()
+	job.poll = func(seen func(int) bool, emit func(int, reflect.Value)) {
+		// Collect the outputs of packed jobs which have finished.
+		if err := run.merge(); err != nil {
+			log.Printf("merge outputs: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			if seen(i) {
+				continue
+			}
+			if !run.hasOutput(i) {
+				continue
+			}
+			yi := reflect.New(etyp)
+			// Outputs are renamed into place once complete.
+			if err := run.loadOutput(i, yi.Interface()); err != nil {
+				log.Printf("load output %d: %v", i, err)
+				continue
+			}
+			emit(i, yi.Elem())
 		}
 	}
-	return nil
+	return job, nil
 }
 
 // Ensures that dst has length n and then de-references the pointer.
@@ -9,6 +9,8 @@ type MapError struct {
 	Master error
 	Tasks  map[int]error
 	Len    int
+	// Number of times each failed element was attempted.
+	Attempts map[int]int
 }
 
 func (err MapError) Error() string {
@@ -18,6 +20,12 @@ func (err MapError) Error() string {
 	return fmt.Sprintf("tasks failed %d/%d", len(err.Tasks), err.Len)
 }
 
+// Returns the error which prevented the jobs from finishing, if any.
+// For example, the error of a cancelled context.
+func (err MapError) Unwrap() error {
+	return err.Master
+}
+
 func keys(tasks map[int]error) []int {
 	if len(tasks) == 0 {
 		return nil
@@ -0,0 +1,352 @@
+package dstrfn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+)
+
+// MapReduce computes a map from each key to the reduction of its values.
+//
+// The map task fm is applied to every element of x and must return a map[K]V.
+// The master then writes every key and value to one of several partition files
+// according to the hash of the key.
+// The reduce task fr combines the values of each key into a single V.
+// It must be registered using RegisterReduce, for example with ReduceFunc.
+// The parameter p is passed to both tasks.
+// The output y must be a pointer to a map[K]V.
+// The keys are identified by their JSON encoding, into which they must be able to be decoded.
+//
+// Each partition is reduced by one job, which groups the values of its file by key,
+// so that the reduce is a single array of jobs.
+// The number of partitions is set by the flag -fr.partitions.
+//
+// If the map fails, the error wraps a MapError whose indices refer to x.
+// If the reduce fails, the error wraps a MapError
+// whose indices refer to the partitions which contain at least one key.
+func MapReduce(fm, fr string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	return MapReduceContext(context.Background(), fm, fr, y, x, p, stdout, stderr, flags)
+}
+
+// Maximum number of partitions of a MapReduce,
+// since the master writes to every partition file at once.
+const maxPartitions = 256
+
+// MapReduceContext is like MapReduce but cancels the jobs if the context is done first.
+func MapReduceContext(ctx context.Context, fm, fr string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	mapSpec, there := mapTasks[fm]
+	if !there {
+		return fmt.Errorf(`map task not found: "%s"`, fm)
+	}
+	reduceSpec, there := reduceTasks[fr]
+	if !there {
+		return fmt.Errorf(`reduce task not found: "%s"`, fr)
+	}
+	ytyp := reflect.TypeOf(y).Elem()
+	if ytyp.Kind() != reflect.Map {
+		return fmt.Errorf("output is not a pointer to a map: %v", reflect.TypeOf(y))
+	}
+
+	// Map each element to a map[K]V.
+	z := reflect.New(reflect.SliceOf(ytyp))
+	job, err := submitMap(mapSpec, fm, z.Interface(), x, p, flags)
+	if err != nil {
+		return err
+	}
+	if err := job.WaitContext(ctx); err != nil {
+		return fmt.Errorf("map: %w", err)
+	}
+
+	// Write the values to partition files by key.
+	dir, persist, err := mapDir(fr + "-shuffle")
+	if err != nil {
+		return err
+	}
+	if !persist {
+		defer os.RemoveAll(dir)
+	}
+	n := reduceSpec.Partitions
+	if n <= 0 {
+		n, err = numKeys(z.Elem().Interface())
+		if err != nil {
+			return err
+		}
+	}
+	ext, err := fileExt(reduceSpec.Encoding, reduceSpec.Compress)
+	if err != nil {
+		return err
+	}
+	files, err := shuffle(z.Elem().Interface(), dir, ext, min(n, maxPartitions))
+	if err != nil {
+		return fmt.Errorf("shuffle: %v", err)
+	}
+	out := reflect.MakeMap(ytyp)
+	reflect.ValueOf(y).Elem().Set(out)
+	if len(files) == 0 {
+		return nil
+	}
+
+	// Reduce each partition.
+	spec := *reduceSpec
+	spec.Task = &partitionTask{unchunk(spec.Task)}
+	spec.Chunk = false
+	// The workers must also read the partition files.
+	flags = append([]string{"-dstrfn.shuffle"}, flags...)
+	v := reflect.New(reflect.SliceOf(reflect.MapOf(reflect.TypeOf(""), ytyp.Elem())))
+	// The inputs are the names of files, so the outputs cannot be cached.
+	job, err = mapAsyncChunk(&spec, fr, v.Interface(), files, p, flags)
+	if err != nil {
+		return err
+	}
+	if err := job.WaitContext(ctx); err != nil {
+		return fmt.Errorf("reduce: %w", err)
+	}
+	for i := 0; i < v.Elem().Len(); i++ {
+		iter := v.Elem().Index(i).MapRange()
+		for iter.Next() {
+			key := reflect.New(ytyp.Key())
+			if err := json.Unmarshal([]byte(iter.Key().String()), key.Interface()); err != nil {
+				return fmt.Errorf("decode key %s: %v", iter.Key().String(), err)
+			}
+			out.SetMapIndex(key.Elem(), iter.Value())
+		}
+	}
+	return nil
+}
+
+// Returns the JSON encoding of each key of a map in order.
+func encodeKeys(m reflect.Value) ([]string, []reflect.Value, error) {
+	keys := m.MapKeys()
+	enc := make([]string, len(keys))
+	for i, key := range keys {
+		data, err := json.Marshal(key.Interface())
+		if err != nil {
+			return nil, nil, fmt.Errorf("encode key: %v", err)
+		}
+		enc[i] = string(data)
+	}
+	// Order the keys so that the files do not depend on map iteration.
+	sort.Sort(byEnc{enc, keys})
+	return enc, keys, nil
+}
+
+type byEnc struct {
+	enc  []string
+	keys []reflect.Value
+}
+
+func (s byEnc) Len() int           { return len(s.enc) }
+func (s byEnc) Less(i, j int) bool { return s.enc[i] < s.enc[j] }
+func (s byEnc) Swap(i, j int) {
+	s.enc[i], s.enc[j] = s.enc[j], s.enc[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// Counts the distinct keys of a slice []map[K]V.
+func numKeys(z interface{}) (int, error) {
+	zval := reflect.ValueOf(z)
+	seen := make(map[string]bool)
+	for i := 0; i < zval.Len(); i++ {
+		enc, _, err := encodeKeys(zval.Index(i))
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range enc {
+			seen[key] = true
+		}
+	}
+	return len(seen), nil
+}
+
+// Takes a slice []map[K]V and writes every key and value to one of n partition files in dir.
+// The partition of a key is given by the hash of its JSON encoding.
+// Each file is a stream of alternating keys and values
+// in which each key is its JSON encoding.
+// Returns the names of the files which contain at least one key.
+func shuffle(z interface{}, dir, ext string, n int) ([]string, error) {
+	codec, comp, err := parseExt("part." + ext)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]*partitionWriter, max(n, 1))
+	defer func() {
+		// Close any files which remain open after an error.
+		for _, w := range parts {
+			if w != nil {
+				w.Close()
+			}
+		}
+	}()
+
+	zval := reflect.ValueOf(z)
+	for i := 0; i < zval.Len(); i++ {
+		zi := zval.Index(i)
+		enc, keys, err := encodeKeys(zi)
+		if err != nil {
+			return nil, err
+		}
+		for j, key := range keys {
+			h := fnv.New32a()
+			io.WriteString(h, enc[j])
+			k := int(h.Sum32() % uint32(len(parts)))
+			if parts[k] == nil {
+				fname := path.Join(dir, fmt.Sprintf("part-%d.%s", k, ext))
+				parts[k], err = createPartition(fname, codec, comp)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if err := parts[k].Write(enc[j], zi.MapIndex(key).Interface()); err != nil {
+				return nil, fmt.Errorf("partition %d: %v", k, err)
+			}
+		}
+	}
+
+	var files []string
+	for k, w := range parts {
+		if w == nil {
+			continue
+		}
+		parts[k] = nil
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("partition %d: %v", k, err)
+		}
+		files = append(files, w.Name)
+	}
+	return files, nil
+}
+
+// Writes alternating keys and values to a partition file.
+type partitionWriter struct {
+	Name string
+	file *os.File
+	bw   *bufio.Writer
+	// Nil if not compressed.
+	zw  io.WriteCloser
+	enc Encoder
+}
+
+func createPartition(fname string, codec Codec, comp *compressor) (*partitionWriter, error) {
+	file, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	w := &partitionWriter{Name: fname, file: file, bw: bufio.NewWriter(file)}
+	if comp == nil {
+		w.enc = codec.NewEncoder(w.bw)
+		return w, nil
+	}
+	w.zw, err = comp.NewWriter(w.bw)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.enc = codec.NewEncoder(w.zw)
+	return w, nil
+}
+
+func (w *partitionWriter) Write(key string, val interface{}) error {
+	if err := w.enc.Encode(key); err != nil {
+		return err
+	}
+	return w.enc.Encode(val)
+}
+
+func (w *partitionWriter) Close() error {
+	if w.zw != nil {
+		if err := w.zw.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	if err := w.bw.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// A meta-task which reduces the values of each key in a partition file.
+// The input is the name of the file
+// and the output is a map from the JSON encoding of each key to its reduction.
+type partitionTask struct {
+	Task ConfigTask
+}
+
+func (t *partitionTask) NewInput() interface{} {
+	return new(string)
+}
+
+func (t *partitionTask) NewConfig() interface{} {
+	return t.Task.NewConfig()
+}
+
+// Returns a new map from string to the output of the reduce task.
+func (t *partitionTask) NewOutput() interface{} {
+	ytyp := reflect.TypeOf(t.Task.NewOutput()).Elem()
+	return reflect.New(reflect.MapOf(reflect.TypeOf(""), ytyp)).Interface()
+}
+
+func (t *partitionTask) Func(x, p interface{}) (interface{}, error) {
+	fname := x.(string)
+	codec, comp, err := parseExt(fname)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var r io.Reader = bufio.NewReader(file)
+	if comp != nil {
+		zr, err := comp.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	dec := codec.NewDecoder(r)
+
+	// Group the values by key in order of appearance.
+	gtyp := reflect.TypeOf(t.Task.NewInput()).Elem()
+	groups := make(map[string]reflect.Value)
+	var keys []string
+	for {
+		var key string
+		if err := dec.Decode(&key); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decode key: %v", err)
+		}
+		val := reflect.New(gtyp.Elem())
+		if err := dec.Decode(val.Interface()); err != nil {
+			return nil, fmt.Errorf("decode value of key %s: %v", key, err)
+		}
+		g, there := groups[key]
+		if !there {
+			g = reflect.MakeSlice(gtyp, 0, 1)
+			keys = append(keys, key)
+		}
+		groups[key] = reflect.Append(g, val.Elem())
+	}
+
+	y := reflect.ValueOf(t.NewOutput()).Elem()
+	y.Set(reflect.MakeMapWithSize(y.Type(), len(keys)))
+	for _, key := range keys {
+		yk, err := t.Task.Func(groups[key].Interface(), p)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", key, err)
+		}
+		y.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(yk))
+	}
+	return y.Interface(), nil
+}
@@ -0,0 +1,44 @@
+package dstrfn
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestShuffle_Partitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shuffle-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	z := []map[int]float64{{1: 1, 2: 2}, {2: 3, 3: 4}, {1: 5}}
+	files, err := shuffle(z, dir, "msgpack.gz", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 || len(files) > 2 {
+		t.Fatalf("expect 1 or 2 partitions, got %v", files)
+	}
+	// Keep the values of each key in order.
+	task := &partitionTask{ReduceFunc(func(x, y float64) float64 { return 10*x + y })}
+	got := make(map[string]float64)
+	for _, fname := range files {
+		y, err := task.Func(fname, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, val := range y.(map[string]float64) {
+			if _, there := got[key]; there {
+				t.Errorf("key %s in more than one partition", key)
+			}
+			got[key] = val
+		}
+	}
+	want := map[string]float64{"1": 15, "2": 23, "3": 4}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expect %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,251 @@
+package dstrfn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+)
+
+// Pack stores the inputs and outputs of a map in a few large files
+// instead of one file per element.
+// The flag -name.pack overrides this setting.
+// Pack has no effect on tasks registered using Register.
+func Pack(pack bool) Option {
+	return func(spec *taskSpec) {
+		spec.Pack = pack
+	}
+}
+
+// Location of one element within a packed file.
+// An element which is not present has zero length.
+type span struct {
+	Off, Len int64
+}
+
+// Size of one span in an index file.
+const spanSize = 16
+
+// Writes every element of the slice x to one file
+// and the location of each element to an index file.
+// Each element is encoded and compressed separately
+// so that it can be read without reading the others.
+func savePacked(fname, indexFile string, x interface{}) error {
+	codec, comp, err := parseExt(fname)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	bw := bufio.NewWriter(file)
+	w := &countWriter{W: bw}
+
+	xval := reflect.ValueOf(x)
+	index := make([]span, xval.Len())
+	for i := range index {
+		off := w.N
+		if err := encodeTo(w, codec, comp, xval.Index(i).Interface()); err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+		index[i] = span{off, w.N - off}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return saveIndex(indexFile, index)
+}
+
+// Reads one element from a packed file.
+func loadSpan(fname string, s span, v interface{}) error {
+	codec, comp, err := parseExt(fname)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(io.NewSectionReader(file, s.Off, s.Len))
+	return decodeFrom(r, codec, comp, v)
+}
+
+// Appends the contents of the file src to the file dst.
+// Returns the location of the contents in dst.
+func appendFile(dst, src string) (span, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return span{}, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return span{}, err
+	}
+	defer out.Close()
+	off, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return span{}, err
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		return span{}, err
+	}
+	if err := out.Close(); err != nil {
+		return span{}, err
+	}
+	return span{off, n}, nil
+}
+
+// Index files contain the span of each element in order
+// as pairs of little-endian int64s.
+// The index of a segment also gives the element of each span.
+func saveIndex(fname string, index interface{}) error {
+	file, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if err := binary.Write(w, binary.LittleEndian, index); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Loads the index of a file with n elements.
+func loadIndex(fname string, n int) ([]span, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	index := make([]span, n)
+	if err := binary.Read(bufio.NewReader(file), binary.LittleEndian, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Reads the span of element i without reading the rest of the index.
+func readIndex(fname string, i int) (span, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return span{}, err
+	}
+	defer file.Close()
+	var s span
+	r := io.NewSectionReader(file, int64(i)*spanSize, spanSize)
+	if err := binary.Read(r, binary.LittleEndian, &s); err != nil {
+		return span{}, fmt.Errorf("read index %d: %v", i, err)
+	}
+	return s, nil
+}
+
+// Location of the output of one element within a segment.
+type segSpan struct {
+	Elem int64
+	span
+}
+
+// Writes the outputs of one job of a packed map to a segment.
+// Each output is encoded and compressed separately.
+// The element and location of each output are written to the index of the segment
+// once the segment is complete.
+type segWriter struct {
+	fname, indexFile string
+	codec            Codec
+	comp             *compressor
+	file             *os.File
+	bw               *bufio.Writer
+	w                *countWriter
+	index            []segSpan
+}
+
+func createSeg(fname, indexFile string) (*segWriter, error) {
+	codec, comp, err := parseExt(fname)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(file)
+	s := &segWriter{fname: fname, indexFile: indexFile, codec: codec, comp: comp, file: file, bw: bw, w: &countWriter{W: bw}}
+	return s, nil
+}
+
+// Appends the output y of element i.
+func (s *segWriter) Add(i int, y interface{}) error {
+	off := s.w.N
+	if err := encodeTo(s.w, s.codec, s.comp, y); err != nil {
+		return err
+	}
+	s.index = append(s.index, segSpan{int64(i), span{off, s.w.N - off}})
+	return nil
+}
+
+// Closes the segment and then saves its index.
+// The index is renamed into place,
+// so a segment whose index exists has been completely written.
+func (s *segWriter) Close() error {
+	if err := s.bw.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(s.indexFile), "tmp-"+path.Base(s.indexFile))
+	if err := saveIndex(tmp, s.index); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.indexFile)
+}
+
+// Loads the index of a segment.
+func loadSegIndex(fname string) ([]segSpan, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%segSpanSize != 0 {
+		return nil, fmt.Errorf("index size is not a multiple of %d: %d", segSpanSize, len(data))
+	}
+	index := make([]segSpan, len(data)/segSpanSize)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Size of one entry in the index of a segment.
+const segSpanSize = 24
+
+// Counts the number of bytes written.
+type countWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.W.Write(p)
+	w.N += int64(n)
+	return n, err
+}
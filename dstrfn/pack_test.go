@@ -0,0 +1,108 @@
+package dstrfn
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestPacked_Seek(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pack-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	x := []string{"a", "bcd", "", "efghij"}
+	fname, indexFile := path.Join(dir, "in.json.zst"), path.Join(dir, "in.index")
+	if err := savePacked(fname, indexFile, x); err != nil {
+		t.Fatal(err)
+	}
+	// Read the elements out of order.
+	for _, i := range []int{3, 0, 2, 1} {
+		s, err := readIndex(indexFile, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var xi string
+		if err := loadSpan(fname, s, &xi); err != nil {
+			t.Fatal(err)
+		}
+		if xi != x[i] {
+			t.Errorf("element %d: expect %q, got %q", i, x[i], xi)
+		}
+	}
+	if _, err := readIndex(indexFile, len(x)); err == nil {
+		t.Error("expect error for index out of range")
+	}
+}
+
+func TestMapRun_MergeSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pack-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	task := &mapTaskSpec{taskSpec: taskSpec{Encoding: "json", Pack: true}}
+	r, err := newMapRun(task, "test", dir, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two jobs, one of which failed to produce element 3.
+	x := [][]string{{"a", "bc"}, {"def", "", "ghij"}}
+	for j, xj := range x {
+		name := path.Join(dir, fmt.Sprintf("seg-0-%d", j))
+		seg, err := createSeg(name+".json", name+".index")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, xjk := range xj {
+			i := 2*j + k
+			if i == 3 {
+				continue
+			}
+			if err := seg.Add(i, xjk); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A segment without an index has not finished.
+	if err := ioutil.WriteFile(path.Join(dir, "seg-0-2.json"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a", "bc", "def", "", "ghij"}
+	for i := range want {
+		if i == 3 {
+			if r.hasOutput(i) {
+				t.Errorf("element %d: expect no output", i)
+			}
+			continue
+		}
+		var y string
+		if err := r.loadOutput(i, &y); err != nil {
+			t.Errorf("element %d: %v", i, err)
+			continue
+		}
+		if y != want[i] {
+			t.Errorf("element %d: expect %q, got %q", i, want[i], y)
+		}
+	}
+	files, err := filepath.Glob(path.Join(dir, "seg-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expect only the unfinished segment, got %v", files)
+	}
+}
@@ -0,0 +1,127 @@
+package dstrfn
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// Submits jobs to PBS Pro using qsub.
+type pbsScheduler struct{}
+
+// If n is greater than 1, the -J argument is supplied.
+func (pbsScheduler) Submit(spec *JobSpec) (Handle, error) {
+	var args []string
+	// Set task name.
+	args = append(args, "-N", spec.Name)
+	// Set number of jobs.
+	if spec.Len > 1 {
+		args = append(args, "-J", fmt.Sprintf("1-%d", spec.Len))
+	}
+	// Put stdout and stderr in temporary dir.
+	args = append(args, "-e", path.Clean(spec.Dir)+"/")
+	args = append(args, "-o", path.Clean(spec.Dir)+"/")
+	// Wait for all jobs to finish.
+	args = append(args, "-W", "block=TRUE,sandbox=PRIVATE")
+	// Use same environment variables.
+	args = append(args, "-V")
+	// Set resources.
+	if len(spec.Flags) > 0 {
+		args = append(args, strings.Split(spec.Flags, " ")...)
+	}
+	args = append(args, "--", spec.Path)
+	if len(spec.Args) > 0 {
+		args = append(args, spec.Args...)
+	}
+
+	cmd := exec.Command("qsub", args...)
+	// Re-route stderr. Stdout is read to obtain the job ID.
+	cmd.Stderr = spec.Stderr
+	log.Printf("qsub arguments: %#v", args)
+	// qsub prints the job ID on submission, then blocks.
+	job, err := startBlocking(cmd, spec.Stdout, func(id string) string { return id })
+	if err != nil {
+		return nil, err
+	}
+	return &pbsJob{job}, nil
+}
+
+func (pbsScheduler) WorkDir() (string, error) {
+	return getenv("PBS_O_WORKDIR")
+}
+
+// PBS array indices start at one.
+func (pbsScheduler) ArrayIndex() (int, error) {
+	ind, err := getenvInt("PBS_ARRAY_INDEX")
+	if err != nil {
+		return 0, err
+	}
+	return ind - 1, nil
+}
+
+// Array of jobs submitted to PBS Pro.
+type pbsJob struct {
+	*blockingJob
+}
+
+// Deletes the job using qdel.
+// The blocking qsub command exits once the job has been deleted.
+func (j *pbsJob) Cancel() error {
+	out, err := exec.Command("qdel", j.id).CombinedOutput()
+	// Do not wait for the submission command to notice.
+	j.kill()
+	if err != nil {
+		return fmt.Errorf("qdel: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Queries the state of each subjob using qstat.
+// Finished subjobs are included using -x.
+func (j *pbsJob) Status() (Status, error) {
+	out, err := exec.Command("qstat", "-t", "-x", "-f", "-F", "json", j.id).Output()
+	if err != nil {
+		return Status{}, fmt.Errorf("qstat: %v", err)
+	}
+	return parseQstat(out)
+}
+
+// Describes the output of qstat -f -F json.
+type qstatOutput struct {
+	Jobs map[string]struct {
+		State      string `json:"job_state"`
+		ExitStatus *int   `json:"Exit_status"`
+	}
+}
+
+// Counts the jobs in the output of qstat -t -f -F json.
+// A finished job which did not exit with status zero is considered failed.
+func parseQstat(data []byte) (Status, error) {
+	var out qstatOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Status{}, fmt.Errorf("parse qstat output: %v", err)
+	}
+	var s Status
+	for id, job := range out.Jobs {
+		// The parent of an array job is listed alongside its subjobs.
+		if strings.Contains(id, "[]") {
+			continue
+		}
+		switch job.State {
+		case "R", "E":
+			s.Running++
+		case "X", "F":
+			if job.ExitStatus != nil && *job.ExitStatus == 0 {
+				s.Done++
+			} else {
+				s.Failed++
+			}
+		default:
+			s.Queued++
+		}
+	}
+	return s, nil
+}
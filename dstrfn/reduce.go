@@ -0,0 +1,94 @@
+package dstrfn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Reduce is implemented as log_k(n) maps from lists of groups to lists of values.
+// Each map corresponds to one level of a k-ary tree,
+// where k is set by the flag -name.arity.
+//
+// The input x is a slice of type []T and the output y is a pointer of type *T.
+// The function f is identified by name and must be registered using RegisterReduce.
+// The task maps a group []T of up to k elements to a single T.
+// The parameter p is passed to every map, as in Map.
+func Reduce(f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	return ReduceContext(context.Background(), f, y, x, p, stdout, stderr, flags)
+}
+
+// ReduceContext is like Reduce but cancels the jobs if the context is done first.
+// The error is then the MapError of the level of the tree which was interrupted.
+func ReduceContext(ctx context.Context, f string, y, x, p interface{}, stdout, stderr io.Writer, flags []string) error {
+	task, there := reduceTasks[f]
+	if !there {
+		return fmt.Errorf(`reduce task not found: "%s"`, f)
+	}
+	if reflect.ValueOf(x).Len() == 0 {
+		return errors.New("reduce empty list")
+	}
+	out, err := reduce(ctx, task, f, x, p, flags)
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(y).Elem().Set(reflect.ValueOf(out))
+	return nil
+}
+
+func reduce(ctx context.Context, task *mapTaskSpec, f string, x, p interface{}, flags []string) (interface{}, error) {
+	// If there is only one element, return it.
+	xval := reflect.ValueOf(x)
+	if xval.Len() < 2 {
+		return xval.Index(0).Interface(), nil
+	}
+	y, err := shrink(ctx, task, f, x, p, max(task.Arity, 2), flags)
+	if err != nil {
+		return nil, err
+	}
+	return reduce(ctx, task, f, y, p, flags)
+}
+
+// Maps n elements to ceil(n/k) elements.
+// The input x must be a slice of type []T.
+// Returns a slice of type []T.
+func shrink(ctx context.Context, task *mapTaskSpec, f string, x, p interface{}, k int, flags []string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	xval := reflect.ValueOf(x)
+	n := xval.Len()
+	// The last group may be smaller than the others.
+	// A group of one element does not need to be submitted.
+	m := ceilDiv(n, k)
+	if n%k == 1 {
+		m--
+	}
+
+	// Construct a list of groups of type []T.
+	typ := xval.Type()
+	groups := reflect.MakeSlice(reflect.SliceOf(typ), m, m)
+	for i := 0; i < m; i++ {
+		groups.Index(i).Set(xval.Slice(i*k, min((i+1)*k, n)))
+	}
+
+	// Make a slice to assign the results to.
+	// Includes capacity for the last element if it was not submitted.
+	y := reflect.New(typ)
+	y.Elem().Set(reflect.MakeSlice(typ, m, m+1))
+	job, err := submitMap(task, f, y.Interface(), groups.Interface(), p, flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := job.WaitContext(ctx); err != nil {
+		return nil, err
+	}
+	// Bring the last element forward.
+	out := y.Elem()
+	if m*k < n {
+		out = reflect.Append(out, xval.Index(n-1))
+	}
+	return out.Interface(), nil
+}
@@ -0,0 +1,122 @@
+package dstrfn
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ReduceFunc creates a reduce task from a function.
+// A reduce task maps a group of values to a single value
+// by combining them two at a time from left to right.
+//
+// The first two arguments and the first return value of f must have the same type T.
+// This type must be concrete.
+// The remaining arguments are held constant, as in ConfigFunc.
+// The function must have either one or two outputs.
+// The second output, if present, must be an error.
+//
+// Examples:
+//	var (
+//		sum   = dstrfn.ReduceFunc(func(x, y float64) float64 { return x + y })
+//		pnorm = dstrfn.ReduceFunc(func(x, y, p float64) float64 {
+//			return math.Pow(math.Pow(x, p)+math.Pow(y, p), 1/p)
+//		})
+//	)
+//
+// The input of the task is a slice []T of at least one element.
+// The number of elements in each group is set by the flag -name.arity.
+// ReduceFunc tasks should be registered using RegisterReduce()
+// and invoked using Reduce().
+func ReduceFunc(f interface{}) ConfigTask {
+	ftyp := reflect.TypeOf(f)
+	if ftyp.Kind() != reflect.Func {
+		panic(fmt.Sprintf("not func: %v", ftyp.Kind()))
+	}
+	if n := ftyp.NumIn(); n < 2 {
+		panic(fmt.Sprintf("expect at least two inputs: %d", n))
+	}
+	if n := ftyp.NumOut(); n == 0 {
+		panic("expect at least one output")
+	} else if n > 2 {
+		panic(fmt.Sprintf("more than two outputs: %d", n))
+	} else if n == 2 {
+		errtyp := ftyp.Out(1)
+		if !isError(errtyp) {
+			panic(fmt.Sprintf("output type is not error: %v", errtyp))
+		}
+	}
+	t := ftyp.In(0)
+	if ftyp.In(1) != t || ftyp.Out(0) != t {
+		panic(fmt.Sprintf("expect func(%v, %v, ...) %v", t, t, t))
+	}
+	return &reduceTask{f}
+}
+
+// Task defined by a function.
+type reduceTask struct {
+	F interface{}
+}
+
+// Returns a new slice of the type of the first argument.
+func (t *reduceTask) NewInput() interface{} {
+	ftyp := reflect.TypeOf(t.F)
+	return reflect.New(reflect.SliceOf(ftyp.In(0))).Interface()
+}
+
+// Creates a list of new objects with the types of the remaining arguments.
+// Returns nil if there are only two arguments.
+func (t *reduceTask) NewConfig() interface{} {
+	ftyp := reflect.TypeOf(t.F)
+	n := ftyp.NumIn() - 2
+	if n == 0 {
+		return nil
+	}
+	in := make([]interface{}, n)
+	for i := range in {
+		in[i] = reflect.New(ftyp.In(i + 2)).Interface()
+	}
+	return &in
+}
+
+// Returns a new object of the type of the first return value.
+func (t *reduceTask) NewOutput() interface{} {
+	ftyp := reflect.TypeOf(t.F)
+	return reflect.New(ftyp.Out(0)).Interface()
+}
+
+// Combines the elements of x from left to right.
+// If function only takes two arguments then p is ignored.
+func (t *reduceTask) Func(x, p interface{}) (interface{}, error) {
+	fval := reflect.ValueOf(t.F)
+	ftyp := fval.Type()
+	// Panics if x is not a slice.
+	xval := reflect.ValueOf(x)
+	if xval.Len() == 0 {
+		return nil, errors.New("reduce empty group")
+	}
+	// Additional arguments if there are any.
+	var args []reflect.Value
+	if ftyp.NumIn() > 2 {
+		for _, arg := range p.([]interface{}) {
+			// De-reference each element.
+			args = append(args, reflect.ValueOf(arg).Elem())
+		}
+	}
+
+	y := xval.Index(0)
+	for i := 1; i < xval.Len(); i++ {
+		in := append([]reflect.Value{y, xval.Index(i)}, args...)
+		// Panics if call is invalid.
+		out := fval.Call(in)
+		if len(out) > 1 {
+			err := out[1].Interface()
+			if err != nil {
+				// Panics if second return value is not assignable to error.
+				return nil, err.(error)
+			}
+		}
+		y = out[0]
+	}
+	return y.Interface(), nil
+}
@@ -3,11 +3,13 @@ package dstrfn
 import (
 	"flag"
 	"fmt"
+	"time"
 )
 
 var (
-	tasks    = make(map[string]*taskSpec)
-	mapTasks = make(map[string]*mapTaskSpec)
+	tasks       = make(map[string]*taskSpec)
+	mapTasks    = make(map[string]*mapTaskSpec)
+	reduceTasks = make(map[string]*mapTaskSpec)
 )
 
 // Task for submission.
@@ -18,51 +20,135 @@ type taskSpec struct {
 	Flags string
 	// Keep stdout and stderr of tasks?
 	Stdout, Stderr bool
+	// Maximum number of attempts for each element of a map
+	// and delay before the first retry.
+	Attempts int
+	Backoff  time.Duration
+	// Version of the function for the purpose of caching.
+	Version string
+	// Name of the codec for inputs, parameters and outputs.
+	Encoding string
+	// Name of the compression of files, empty for none.
+	Compress string
+	// Store the inputs and outputs of a map in packed files?
+	Pack bool
+	// Group the elements of a map into chunks?
+	// Set by RegisterMap or the Chunk option.
+	Chunk bool
+}
+
+// Option modifies the default settings of a task.
+// The settings can still be overridden using command-line flags.
+type Option func(*taskSpec)
+
+// Retry sets the maximum number of attempts for each element of a map.
+// Elements which fail are submitted again as a smaller array.
+// The delay before the first retry is backoff,
+// and the delay doubles after every attempt.
+//
+// The flags -name.attempts and -name.backoff override these settings.
+// Retry has no effect on tasks registered using Register.
+func Retry(attempts int, backoff time.Duration) Option {
+	return func(spec *taskSpec) {
+		spec.Attempts = attempts
+		spec.Backoff = backoff
+	}
+}
+
+// Chunk groups the elements of a map into chunks
+// which are each performed by one job.
+// The flag -name.chunk-len sets the number of elements in each chunk.
+// Chunk(true) is equivalent to the chunk argument of RegisterMap.
+// Chunk has no effect on tasks registered using Register.
+func Chunk(chunk bool) Option {
+	return func(spec *taskSpec) {
+		spec.Chunk = chunk
+	}
 }
 
 type mapTaskSpec struct {
 	taskSpec
-	// Group jobs into chunks?
-	// Chunk is set in Register(), ChunkLen is set by a flag.
-	Chunk    bool
+	// Number of elements in each chunk if the task is chunked.
+	// Set by a flag.
 	ChunkLen int
+	// Number of elements combined by each job of a reduce
+	// and number of jobs for the reduce of a MapReduce.
+	// Set by flags for tasks registered using RegisterReduce.
+	Arity      int
+	Partitions int
 }
 
 // Registers a task to a name.
 // The name must be able to be part of a command-line flag.
 // The task must implement Task or ConfigTask.
-func Register(name string, task interface{}) {
-	register(name, toConfigTask(task))
+func Register(name string, task interface{}, opts ...Option) {
+	register(name, toConfigTask(task), opts...)
 }
 
 // Chunking is only supported for "simple" types.
 // That is, types X which can be decoded from JSON into new([]X).
-func RegisterMap(name string, chunk bool, task interface{}) {
-	registerMap(name, chunk, toConfigTask(task))
+func RegisterMap(name string, chunk bool, task interface{}, opts ...Option) {
+	registerMap(name, chunk, toConfigTask(task), opts...)
 }
 
-func register(name string, task ConfigTask) {
+// Registers a reduce task to a name.
+// The task maps a group of elements to a single element,
+// for example a task created using ReduceFunc.
+// Reduce tasks are invoked using Reduce() not Map().
+//
+// The flag -name.arity sets the number of elements in each group.
+// The flag -name.partitions sets the number of jobs when used in MapReduce.
+func RegisterReduce(name string, chunk bool, task interface{}, opts ...Option) {
+	if nameUsed(name) {
+		panic(fmt.Sprintf(`name already registered: "%s"`, name))
+	}
+	spec := newMapSpec(name, chunk, toConfigTask(task), opts...)
+	flag.IntVar(&spec.Arity, name+".arity", 2, "Number of elements combined by each job. At least 2.")
+	flag.IntVar(&spec.Partitions, name+".partitions", 0, fmt.Sprintf("Number of jobs in a MapReduce, at most %d. Zero to use the number of keys.", maxPartitions))
+	reduceTasks[name] = spec
+}
+
+func register(name string, task ConfigTask, opts ...Option) {
 	if nameUsed(name) {
 		panic(fmt.Sprintf(`name already registered: "%s"`, name))
 	}
 	spec := &taskSpec{Task: task}
+	applyOptions(spec, opts)
 	registerSpecFlags(name, spec)
 	tasks[name] = spec
 }
 
-func registerMap(name string, chunk bool, task ConfigTask) {
+func registerMap(name string, chunk bool, task ConfigTask, opts ...Option) {
 	if nameUsed(name) {
 		panic(fmt.Sprintf(`name already registered: "%s"`, name))
 	}
-	if chunk {
+	mapTasks[name] = newMapSpec(name, chunk, task, opts...)
+}
+
+// Creates the settings of a map task and registers its flags.
+func newMapSpec(name string, chunk bool, task ConfigTask, opts ...Option) *mapTaskSpec {
+	spec := new(mapTaskSpec)
+	applyOptions(&spec.taskSpec, opts)
+	spec.Chunk = spec.Chunk || chunk
+	if spec.Chunk {
 		task = &chunkTask{task}
 	}
-	spec := new(mapTaskSpec)
 	spec.Task = task
-	spec.Chunk = chunk
 	registerSpecFlags(name, &spec.taskSpec)
 	flag.IntVar(&spec.ChunkLen, name+".chunk-len", 1, "Split into chunks of up to this many elements.")
-	mapTasks[name] = spec
+	flag.IntVar(&spec.Attempts, name+".attempts", spec.Attempts, "Maximum number of attempts for each element.")
+	flag.DurationVar(&spec.Backoff, name+".backoff", spec.Backoff, "Delay before retrying failed elements. Doubles after each attempt.")
+	flag.BoolVar(&spec.Pack, name+".pack", spec.Pack, "Store inputs and outputs in packed files instead of one file per element.")
+	return spec
+}
+
+// Sets the default settings and then applies the options.
+func applyOptions(spec *taskSpec, opts []Option) {
+	spec.Attempts = 1
+	spec.Encoding = "json"
+	for _, opt := range opts {
+		opt(spec)
+	}
 }
 
 func nameUsed(name string) bool {
@@ -72,6 +158,9 @@ func nameUsed(name string) bool {
 	if _, used := mapTasks[name]; used {
 		return true
 	}
+	if _, used := reduceTasks[name]; used {
+		return true
+	}
 	return false
 }
 
@@ -79,6 +168,8 @@ func registerSpecFlags(name string, spec *taskSpec) {
 	flag.StringVar(&spec.Flags, name+".flags", "", "Additional flags")
 	flag.BoolVar(&spec.Stdout, name+".stdout", false, "Keep stdout?")
 	flag.BoolVar(&spec.Stderr, name+".stderr", false, "Keep stderr?")
+	flag.StringVar(&spec.Encoding, name+".encoding", spec.Encoding, "Codec for inputs and outputs (json, gob, msgpack, binary).")
+	flag.StringVar(&spec.Compress, name+".compress", spec.Compress, "Compression of input and output files (gzip, zstd). Empty for none.")
 }
 
 func toConfigTask(task interface{}) ConfigTask {
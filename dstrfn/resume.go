@@ -0,0 +1,142 @@
+package dstrfn
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+
+	"github.com/jvlmdr/go-file/fileutil"
+)
+
+var (
+	resumeDir string
+	// Number of maps of each task so far.
+	resumeMu  sync.Mutex
+	resumeSeq = make(map[string]int)
+)
+
+func init() {
+	flag.StringVar(&resumeDir, "dstrfn.resume", "", "Directory in which to keep map runs so that the program can be resumed. Empty to use temporary directories.")
+}
+
+// Returns the directory in which to perform a map of task f.
+//
+// If -dstrfn.resume is empty, creates a temporary directory.
+// Otherwise returns a directory which persists between invocations of the program.
+// Its name depends on the number of previous maps of task f,
+// so the program must perform the same maps in the same order when it is resumed.
+func mapDir(f string) (dir string, persist bool, err error) {
+	if len(resumeDir) == 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", false, err
+		}
+		dir, err := ioutil.TempDir(wd, f+"-")
+		if err != nil {
+			return "", false, err
+		}
+		return dir, false, nil
+	}
+
+	dir = path.Join(resumeDir, fmt.Sprintf("%s-%d", f, reserveMapSeq(f)))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", false, err
+	}
+	return dir, true, nil
+}
+
+// Returns the number of previous maps of task f and counts the current one.
+// A map which does not need a directory must still call this,
+// so that the maps which follow it are given the same directories when resumed.
+func reserveMapSeq(f string) int {
+	resumeMu.Lock()
+	defer resumeMu.Unlock()
+	seq := resumeSeq[f]
+	resumeSeq[f]++
+	return seq
+}
+
+// Describes a map run so that it can be checked before resuming.
+type runInfo struct {
+	Task     string
+	Len      int
+	ChunkLen int
+	Encoding string
+	Compress string
+	Pack     bool
+	// Hash of the inputs and config,
+	// so that outputs of different inputs are not reused.
+	Digest string
+}
+
+// Prepares a persistent run directory.
+// If the directory contains a previous run of the same map,
+// loads every valid output into y and returns the elements which are missing.
+// Otherwise returns nil to indicate that every element must be computed.
+// Outputs are renamed into place by the worker,
+// so an output which exists was completely written.
+func (r *mapRun) resume(y, x, p interface{}) ([]int, error) {
+	digest, err := cacheKey(r.Name, r.Task.Version, r.Task.Encoding, x, p)
+	if err != nil {
+		return nil, fmt.Errorf("hash inputs: %v", err)
+	}
+	info := runInfo{Task: r.Name, Len: r.Len, Encoding: r.Task.Encoding, Compress: r.Task.Compress, Pack: r.Task.Pack, Digest: digest}
+	if r.Task.Chunk {
+		info.ChunkLen = r.Task.ChunkLen
+	}
+	infoFile := path.Join(r.Dir, "run.json")
+	if _, err := os.Stat(infoFile); os.IsNotExist(err) {
+		// New run.
+		if err := fileutil.SaveExt(infoFile, info); err != nil {
+			return nil, fmt.Errorf("save run info: %v", err)
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var prev runInfo
+	if err := fileutil.LoadExt(infoFile, &prev); err != nil {
+		return nil, fmt.Errorf("load run info: %v", err)
+	}
+	if prev != info {
+		return nil, fmt.Errorf("cannot resume %s: previous run was %+v, current is %+v", r.Dir, prev, info)
+	}
+
+	// Collect the outputs of packed jobs which finished after the previous run ended.
+	if r.Task.Pack {
+		if err := r.loadOutIndex(); err != nil {
+			return nil, err
+		}
+		if err := r.merge(); err != nil {
+			return nil, err
+		}
+	}
+
+	todo := make([]int, 0)
+	for i := 0; i < r.Len; i++ {
+		if !r.hasOutput(i) {
+			todo = append(todo, i)
+			continue
+		}
+		yi := reflect.ValueOf(y).Index(i).Addr().Interface()
+		if err := r.load(i, yi); err != nil {
+			log.Printf("resume %s: element %d: %v", r.Dir, i, err)
+			todo = append(todo, i)
+			continue
+		}
+	}
+	// Do not load an error from the previous run.
+	for _, i := range todo {
+		if err := r.clear(i); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("resume %s: %d of %d elements remaining", r.Dir, len(todo), r.Len)
+	return todo, nil
+}
@@ -0,0 +1,281 @@
+package dstrfn
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jvlmdr/go-file/fileutil"
+)
+
+// Directory containing the inputs and outputs of a map.
+// Element i has the files in-i.ext, out-i.ext and err-i.json,
+// where ext is the name of the codec followed by that of the compression.
+//
+// If the task is packed, the inputs are instead in the file in.ext,
+// whose index in.index gives the location of each element.
+// Each job performs Group consecutive elements of those submitted
+// and writes their outputs to one segment seg-s-j.ext,
+// where s is the number of the submission and j is the array index of the job.
+// The index seg-s-j.index gives the element and location of each output in the segment.
+// The master appends the segments to out.ext with index out.index.
+// Errors are saved in err-i.json as a TaskError either way.
+type mapRun struct {
+	Task  *mapTaskSpec
+	Name  string
+	Dir   string
+	Len   int
+	Flags []string
+	Ext   string
+	// Number of elements performed by each job.
+	// Greater than one only if the task is packed and chunked.
+	Group int
+	// Number of index files written so far.
+	numIndex int
+	// Location of each merged output if the task is packed.
+	// Guarded by mu since outputs can be polled during a merge.
+	mu       sync.Mutex
+	outIndex []span
+}
+
+func newMapRun(task *mapTaskSpec, name, dir string, n int, flags []string) (*mapRun, error) {
+	ext, err := fileExt(task.Encoding, task.Compress)
+	if err != nil {
+		return nil, err
+	}
+	r := &mapRun{Task: task, Name: name, Dir: dir, Len: n, Flags: flags, Ext: ext, Group: 1}
+	if task.Pack {
+		r.outIndex = make([]span, n)
+		if task.Chunk {
+			r.Group = max(task.ChunkLen, 1)
+		}
+	}
+	return r, nil
+}
+
+func (r *mapRun) inFile(i int) string {
+	return path.Join(r.Dir, fmt.Sprintf("in-%d.%s", i, r.Ext))
+}
+
+func (r *mapRun) outFile(i int) string {
+	return path.Join(r.Dir, fmt.Sprintf("out-%d.%s", i, r.Ext))
+}
+
+// Errors are always saved as JSON.
+func (r *mapRun) errFile(i int) string {
+	return path.Join(r.Dir, fmt.Sprintf("err-%d.json", i))
+}
+
+func (r *mapRun) confFile() string {
+	return path.Join(r.Dir, "conf."+r.Ext)
+}
+
+func (r *mapRun) packedFile(prefix string) string {
+	return path.Join(r.Dir, prefix+"."+r.Ext)
+}
+
+func (r *mapRun) indexFile(prefix string) string {
+	return path.Join(r.Dir, prefix+".index")
+}
+
+// Saves the inputs of the elements in inds.
+// If inds is nil, saves every input.
+// A packed run always saves every input.
+func (r *mapRun) saveInputs(x interface{}, inds []int) error {
+	if r.Task.Pack {
+		if err := savePacked(r.packedFile("in"), r.indexFile("in"), x); err != nil {
+			return fmt.Errorf("save inputs: %v", err)
+		}
+		return nil
+	}
+	xval := reflect.ValueOf(x)
+	save := func(i int) error {
+		if err := saveFile(r.inFile(i), xval.Index(i).Interface()); err != nil {
+			return fmt.Errorf("save input %d: %v", i, err)
+		}
+		return nil
+	}
+	if inds == nil {
+		for i := 0; i < r.Len; i++ {
+			if err := save(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, i := range inds {
+		if err := save(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Submits one job for each group of elements in inds.
+// If inds is nil, submits jobs for every element.
+func (r *mapRun) submit(inds []int) (Handle, error) {
+	n := r.Len
+	jobargs := []string{"-dstrfn.task", r.Name, "-dstrfn.dir", r.Dir, "-dstrfn.encoding", r.Task.Encoding}
+	if len(r.Task.Compress) > 0 {
+		jobargs = append(jobargs, "-dstrfn.compress", r.Task.Compress)
+	}
+	if inds != nil {
+		// Give the array index of each element to the workers.
+		r.numIndex++
+		indexFile := fmt.Sprintf("index-%d.json", r.numIndex)
+		if err := fileutil.SaveExt(path.Join(r.Dir, indexFile), inds); err != nil {
+			return nil, fmt.Errorf("save index: %v", err)
+		}
+		jobargs = append(jobargs, "-dstrfn.index", indexFile)
+		n = len(inds)
+	}
+	if r.Task.Pack {
+		// The segments of each submission have different names.
+		jobargs = append(jobargs, "-dstrfn.pack", "-dstrfn.segment", fmt.Sprint(r.numIndex))
+		if r.Group > 1 {
+			jobargs = append(jobargs, "-dstrfn.group", fmt.Sprint(r.Group))
+		}
+	}
+	jobargs = append(jobargs, "-dstrfn.map", fmt.Sprint(n))
+	if len(r.Flags) > 0 {
+		jobargs = append(jobargs, r.Flags...)
+	}
+	return submit(ceilDiv(n, r.Group), jobargs, r.Name, r.Dir, r.Task.Flags, nil, nil)
+}
+
+// Appends the finished segments to the packed output file and removes them.
+// A segment is finished once its index exists.
+// Does nothing if the task is not packed.
+func (r *mapRun) merge() error {
+	if !r.Task.Pack {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	indexFiles, err := filepath.Glob(path.Join(r.Dir, "seg-*.index"))
+	if err != nil {
+		return err
+	}
+	if len(indexFiles) == 0 {
+		return nil
+	}
+	var segFiles []string
+	for _, indexFile := range indexFiles {
+		segFile := strings.TrimSuffix(indexFile, ".index") + "." + r.Ext
+		index, err := loadSegIndex(indexFile)
+		if err != nil {
+			return fmt.Errorf("load index of %s: %v", segFile, err)
+		}
+		s, err := appendFile(r.packedFile("out"), segFile)
+		if err != nil {
+			return fmt.Errorf("merge %s: %v", segFile, err)
+		}
+		for _, e := range index {
+			if e.Elem < 0 || e.Elem >= int64(r.Len) {
+				return fmt.Errorf("merge %s: element out of range: %d", segFile, e.Elem)
+			}
+			r.outIndex[e.Elem] = span{s.Off + e.Off, e.Len}
+		}
+		segFiles = append(segFiles, segFile)
+	}
+	// Save the index before removing the segments
+	// so that no output is lost if the program is interrupted.
+	if err := saveIndex(r.indexFile("out"), r.outIndex); err != nil {
+		return fmt.Errorf("save output index: %v", err)
+	}
+	for i, segFile := range segFiles {
+		if err := os.Remove(indexFiles[i]); err != nil {
+			return err
+		}
+		if err := os.Remove(segFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads the index of a previous packed run if there is one.
+func (r *mapRun) loadOutIndex() error {
+	if _, err := os.Stat(r.indexFile("out")); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	index, err := loadIndex(r.indexFile("out"), r.Len)
+	if err != nil {
+		return fmt.Errorf("load output index: %v", err)
+	}
+	r.mu.Lock()
+	r.outIndex = index
+	r.mu.Unlock()
+	return nil
+}
+
+// Reports whether the output of element i exists.
+// The output of a packed task only exists once it has been merged.
+func (r *mapRun) hasOutput(i int) bool {
+	if r.Task.Pack {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.outIndex[i].Len > 0
+	}
+	_, err := os.Stat(r.outFile(i))
+	return err == nil
+}
+
+// Loads the output of element i into y.
+// The worker renames the output into place,
+// so an output which exists has been completely written.
+func (r *mapRun) loadOutput(i int, y interface{}) error {
+	if !r.Task.Pack {
+		return loadFile(r.outFile(i), y)
+	}
+	r.mu.Lock()
+	s := r.outIndex[i]
+	r.mu.Unlock()
+	if s.Len == 0 {
+		return fmt.Errorf("output not merged: element %d", i)
+	}
+	return loadSpan(r.packedFile("out"), s, y)
+}
+
+// Loads the output of element i into y.
+// Returns the error of the task if it failed.
+func (r *mapRun) load(i int, y interface{}) error {
+	errFile := r.errFile(i)
+	if r.hasOutput(i) {
+		// If output exists, attempt to load.
+		if err := r.loadOutput(i, y); err != nil {
+			return fmt.Errorf("load output: %v", err)
+		}
+		return nil
+	}
+	// Output did not exist. Try to load error file.
+	if _, err := os.Stat(errFile); err == nil {
+		// Error file exists. Attempt to load.
+		taskErr := new(TaskError)
+		if err := fileutil.LoadExt(errFile, taskErr); err != nil {
+			return err
+		}
+		return taskErr
+	} else if !os.IsNotExist(err) {
+		// Could not stat file.
+		return err
+	}
+	return fmt.Errorf("could not find output or error files: job %d", i)
+}
+
+// Removes the output and error files of element i
+// so that it can be attempted again.
+func (r *mapRun) clear(i int) error {
+	for _, name := range []string{r.outFile(i), r.errFile(i)} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
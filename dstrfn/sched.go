@@ -0,0 +1,96 @@
+package dstrfn
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Scheduler submits arrays of jobs to a batch system.
+//
+// The scheduler is selected by name using the -dstrfn.backend flag.
+// The default is PBS Pro.
+type Scheduler interface {
+	// Submits an array of jobs and returns without waiting for them.
+	Submit(spec *JobSpec) (Handle, error)
+
+	// Returns the directory from which the job was submitted.
+	// Called by the worker.
+	WorkDir() (string, error)
+	// Returns the zero-based index of the job within its array.
+	// Called by the worker.
+	ArrayIndex() (int, error)
+}
+
+// Handle refers to an array of jobs which has been submitted.
+type Handle interface {
+	// Returns the identifier assigned by the scheduler.
+	ID() string
+	// Returns the number of jobs in each state.
+	Status() (Status, error)
+	// Blocks until all jobs in the array have finished.
+	// Returns an error if the jobs could not be executed,
+	// not if the task itself failed.
+	// Must be called exactly once.
+	Wait() error
+	// Removes all jobs in the array from the system.
+	Cancel() error
+}
+
+// Status counts the jobs in an array by state.
+type Status struct {
+	Queued, Running, Done, Failed int
+}
+
+// JobSpec describes an array of jobs.
+type JobSpec struct {
+	// Name of the job.
+	Name string
+	// Number of jobs in the array.
+	Len int
+	// Absolute path of the executable and the arguments to invoke it with.
+	Path string
+	Args []string
+	// Directory to which the jobs write stdout and stderr.
+	Dir string
+	// Additional flags for the scheduler, separated by spaces.
+	Flags string
+	// Where to route stdout and stderr of the submission command.
+	Stdout, Stderr io.Writer
+}
+
+var (
+	schedulers = make(map[string]Scheduler)
+	backend    string
+)
+
+func init() {
+	flag.StringVar(&backend, "dstrfn.backend", "pbs", "Scheduler to which jobs are submitted.")
+	RegisterScheduler("pbs", pbsScheduler{})
+}
+
+// Makes a scheduler available to the -dstrfn.backend flag.
+// The name must not already be in use.
+func RegisterScheduler(name string, sched Scheduler) {
+	if _, used := schedulers[name]; used {
+		panic(fmt.Sprintf(`scheduler already registered: "%s"`, name))
+	}
+	schedulers[name] = sched
+}
+
+// Returns the scheduler selected by -dstrfn.backend.
+func scheduler() (Scheduler, error) {
+	sched, there := schedulers[backend]
+	if !there {
+		return nil, fmt.Errorf(`scheduler not found: "%s"`, backend)
+	}
+	return sched, nil
+}
+
+// Handle for jobs which did not need to be submitted.
+type finishedHandle struct{}
+
+func (finishedHandle) ID() string              { return "" }
+func (finishedHandle) Status() (Status, error) { return Status{}, nil }
+func (finishedHandle) Wait() error             { return nil }
+func (finishedHandle) Cancel() error           { return nil }
@@ -0,0 +1,68 @@
+package dstrfn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQstat(t *testing.T) {
+	const out = `{
+	"timestamp":1412345678,
+	"pbs_version":"12.2.1",
+	"pbs_server":"server",
+	"Jobs":{
+		"123[].server":{"job_state":"B"},
+		"123[1].server":{"job_state":"X","Exit_status":0},
+		"123[2].server":{"job_state":"X","Exit_status":1},
+		"123[3].server":{"job_state":"R"},
+		"123[4].server":{"job_state":"Q"},
+		"123[5].server":{"job_state":"Q"}
+	}
+}`
+	got, err := parseQstat([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Status{Queued: 2, Running: 1, Done: 1, Failed: 1}
+	if got != want {
+		t.Errorf("expect %+v, got %+v", want, got)
+	}
+}
+
+func TestParseSacct(t *testing.T) {
+	const out = `123_0|COMPLETED
+123_1|FAILED
+123_2|CANCELLED by 1000
+123_3|RUNNING
+123_[4-9,12%2]|PENDING
+`
+	got, err := parseSacct([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Status{Queued: 7, Running: 1, Done: 1, Failed: 2}
+	if got != want {
+		t.Errorf("expect %+v, got %+v", want, got)
+	}
+}
+
+func TestSbatchArgs_Array(t *testing.T) {
+	for _, n := range []int{1, 3} {
+		args := strings.Join(sbatchArgs(&JobSpec{Name: "square", Len: n, Dir: "tmp"}), " ")
+		array := strings.Contains(args, "--array=")
+		if n == 1 && array {
+			t.Errorf("len %d: expect no array, got %q", n, args)
+		}
+		if n > 1 && !strings.Contains(args, "--array=0-2") {
+			t.Errorf("len %d: expect --array=0-2, got %q", n, args)
+		}
+	}
+	// Nothing is submitted for zero jobs.
+	h, err := slurmScheduler{}.Submit(&JobSpec{Name: "square", Len: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Wait(); err != nil {
+		t.Errorf("expect no error, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package dstrfn
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterScheduler("slurm", slurmScheduler{})
+}
+
+// Submits jobs to Slurm using sbatch.
+type slurmScheduler struct{}
+
+// If n is greater than 1, the --array argument is supplied.
+// If n is zero, nothing is submitted.
+func (slurmScheduler) Submit(spec *JobSpec) (Handle, error) {
+	if spec.Len < 1 {
+		return finishedHandle{}, nil
+	}
+	args := sbatchArgs(spec)
+	cmd := exec.Command("sbatch", args...)
+	// Re-route stderr. Stdout is read to obtain the job ID.
+	cmd.Stderr = spec.Stderr
+	log.Printf("sbatch arguments: %#v", args)
+	// sbatch prints "jobid[;cluster]" on submission, then blocks.
+	job, err := startBlocking(cmd, spec.Stdout, func(line string) string {
+		if i := strings.Index(line, ";"); i >= 0 {
+			return line[:i]
+		}
+		return line
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &slurmJob{job}, nil
+}
+
+func sbatchArgs(spec *JobSpec) []string {
+	var args []string
+	// Set task name.
+	args = append(args, "--job-name="+spec.Name)
+	// Set number of jobs. Slurm array indices start at zero.
+	// The files of a single job are named by its ID.
+	id := "%j"
+	if spec.Len > 1 {
+		args = append(args, fmt.Sprintf("--array=0-%d", spec.Len-1))
+		id = "%A.%a"
+	}
+	// Put stdout and stderr in temporary dir.
+	args = append(args, "--output="+path.Join(spec.Dir, "%x.o"+id))
+	args = append(args, "--error="+path.Join(spec.Dir, "%x.e"+id))
+	// Wait for all jobs to finish.
+	args = append(args, "--wait")
+	// Use same environment variables.
+	args = append(args, "--export=ALL")
+	// Print only the job ID.
+	args = append(args, "--parsable")
+	// Set resources.
+	if len(spec.Flags) > 0 {
+		args = append(args, strings.Split(spec.Flags, " ")...)
+	}
+	// The command is not a batch script, so sbatch must wrap it in one.
+	cmdline := []string{shellQuote(spec.Path)}
+	for _, arg := range spec.Args {
+		cmdline = append(cmdline, shellQuote(arg))
+	}
+	args = append(args, "--wrap", strings.Join(cmdline, " "))
+	return args
+}
+
+func (slurmScheduler) WorkDir() (string, error) {
+	return getenv("SLURM_SUBMIT_DIR")
+}
+
+func (slurmScheduler) ArrayIndex() (int, error) {
+	return getenvInt("SLURM_ARRAY_TASK_ID")
+}
+
+// Array of jobs submitted to Slurm.
+type slurmJob struct {
+	*blockingJob
+}
+
+// Cancels the job using scancel.
+// The blocking sbatch command exits once the job has been cancelled.
+func (j *slurmJob) Cancel() error {
+	out, err := exec.Command("scancel", j.id).CombinedOutput()
+	// Do not wait for the submission command to notice.
+	j.kill()
+	if err != nil {
+		return fmt.Errorf("scancel: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Queries the state of each array task using sacct.
+func (j *slurmJob) Status() (Status, error) {
+	out, err := exec.Command("sacct", "-j", j.id, "-X", "-n", "-P", "-o", "JobID,State").Output()
+	if err != nil {
+		return Status{}, fmt.Errorf("sacct: %v", err)
+	}
+	return parseSacct(out)
+}
+
+// Counts the jobs in the output of sacct -X -n -P -o JobID,State.
+// Pending tasks may be listed as a range such as "123_[4-9]".
+func parseSacct(data []byte) (Status, error) {
+	var s Status
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) != 2 {
+			return Status{}, fmt.Errorf("parse sacct output: %q", line)
+		}
+		n, err := countArrayTasks(fields[0])
+		if err != nil {
+			return Status{}, fmt.Errorf("parse sacct output: %v", err)
+		}
+		// For example, "CANCELLED by 1234".
+		var state string
+		if words := strings.Fields(fields[1]); len(words) > 0 {
+			state = words[0]
+		}
+		switch state {
+		case "PENDING", "REQUEUED", "RESIZING", "SUSPENDED":
+			s.Queued += n
+		case "RUNNING", "COMPLETING":
+			s.Running += n
+		case "COMPLETED":
+			s.Done += n
+		default:
+			s.Failed += n
+		}
+	}
+	return s, nil
+}
+
+// Counts the tasks in a job ID such as "123_4" or "123_[0-3,7%2]".
+func countArrayTasks(id string) (int, error) {
+	i := strings.Index(id, "_[")
+	if i < 0 {
+		return 1, nil
+	}
+	spec := strings.TrimSuffix(id[i+2:], "]")
+	// Remove limit on number of simultaneous tasks.
+	if k := strings.Index(spec, "%"); k >= 0 {
+		spec = spec[:k]
+	}
+	var n int
+	for _, r := range strings.Split(spec, ",") {
+		a, b := r, r
+		if k := strings.Index(r, "-"); k >= 0 {
+			a, b = r[:k], r[k+1:]
+		}
+		lo, err := strconv.Atoi(a)
+		if err != nil {
+			return 0, err
+		}
+		hi, err := strconv.Atoi(b)
+		if err != nil {
+			return 0, err
+		}
+		n += hi - lo + 1
+	}
+	return n, nil
+}
+
+// Quotes a string for use as a single word in sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,100 @@
+package dstrfn
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var pollInterval time.Duration
+
+func init() {
+	flag.DurationVar(&pollInterval, "dstrfn.poll", time.Second, "Interval at which MapStream looks for outputs.")
+}
+
+// Result is the outcome of one element of MapStream.
+type Result struct {
+	// Index of the element in x.
+	Index int
+	// Output of the element if it succeeded.
+	Value interface{}
+	// Error of the element if it failed.
+	Err error
+}
+
+// MapStream is like Map but sends the result of each element
+// on the returned channel as soon as it is available.
+//
+// The results are not in order.
+// Elements which fail are only sent once they will not be retried.
+// The channel is closed after the result of every element has been sent.
+// The channel is buffered so that the jobs do not wait for the receiver.
+func MapStream(f string, x, p interface{}) (<-chan Result, error) {
+	return MapStreamContext(context.Background(), f, x, p)
+}
+
+// MapStreamContext is like MapStream but cancels the jobs if the context is done first.
+// The elements which did not finish are then sent with an error.
+func MapStreamContext(ctx context.Context, f string, x, p interface{}) (<-chan Result, error) {
+	task, there := mapTasks[f]
+	if !there {
+		return nil, fmt.Errorf(`map task not found: "%s"`, f)
+	}
+	// Determine type of output.
+	inner := task.Task
+	if c, ok := inner.(*chunkTask); ok {
+		inner = c.Task
+	}
+	out := inner.NewOutput()
+	if out == nil {
+		return nil, fmt.Errorf(`map task has no output: "%s"`, f)
+	}
+	n := reflect.ValueOf(x).Len()
+	y := reflect.New(reflect.SliceOf(reflect.TypeOf(out).Elem()))
+	job, err := submitMap(task, f, y.Interface(), x, p, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan Result, n)
+	go func() {
+		defer close(results)
+		done := make(chan error, 1)
+		go func() { done <- job.WaitContext(ctx) }()
+
+		sent := make([]bool, n)
+		seen := func(i int) bool { return sent[i] }
+		emit := func(i int, yi reflect.Value) {
+			results <- Result{Index: i, Value: yi.Interface()}
+			sent[i] = true
+		}
+		tick := time.NewTicker(pollInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				job.poll(seen, emit)
+			case err := <-done:
+				// Send the remaining results once all outputs have been loaded.
+				failed, err := mapFailures(err)
+				for i := 0; i < n; i++ {
+					if sent[i] {
+						continue
+					}
+					switch {
+					case failed == nil:
+						results <- Result{Index: i, Err: err}
+					case failed(i):
+						results <- Result{Index: i, Err: err.(MapError).Tasks[i]}
+					default:
+						emit(i, y.Elem().Index(i))
+					}
+				}
+				return
+			}
+		}
+	}()
+	return results, nil
+}
@@ -1,8 +1,10 @@
 package dstrfn
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -10,28 +12,14 @@ import (
 	"strings"
 )
 
-// If n is greater than 1, the -J argument is supplied.
-func submit(n int, jobargs []string, name, dir, userargs string, subout, suberr io.Writer) (execErr, err error) {
-	var args []string
-	// Set task name.
-	args = append(args, "-N", name)
-	// Set number of jobs.
-	if n > 1 {
-		// TODO: Handle map of 1 task.
-		args = append(args, "-J", fmt.Sprintf("1-%d", n))
+// Submits an array of n jobs using the scheduler selected by -dstrfn.backend.
+// The jobs invoke this executable with the arguments jobargs.
+// The scheduler is passed to the worker using -dstrfn.backend.
+func submit(n int, jobargs []string, name, dir, userargs string, subout, suberr io.Writer) (Handle, error) {
+	sched, err := scheduler()
+	if err != nil {
+		return nil, err
 	}
-	// Put stdout and stderr in temporary dir.
-	args = append(args, "-e", path.Clean(dir)+"/")
-	args = append(args, "-o", path.Clean(dir)+"/")
-	// Wait for all jobs to finish.
-	args = append(args, "-W", "block=TRUE,sandbox=PRIVATE")
-	// Use same environment variables.
-	args = append(args, "-V")
-	// Set resources.
-	if len(userargs) > 0 {
-		args = append(args, strings.Split(userargs, " ")...)
-	}
-
 	// Full path of executable to run.
 	self := os.Args[0]
 	if !path.IsAbs(self) {
@@ -41,15 +29,76 @@ func submit(n int, jobargs []string, name, dir, userargs string, subout, suberr
 		}
 		self = path.Join(wd, os.Args[0])
 	}
-	args = append(args, "--", self)
-	if len(jobargs) > 0 {
-		args = append(args, jobargs...)
+	spec := &JobSpec{
+		Name:   name,
+		Len:    n,
+		Path:   self,
+		Args:   append([]string{"-dstrfn.backend", backend}, jobargs...),
+		Dir:    dir,
+		Flags:  userargs,
+		Stdout: subout,
+		Stderr: suberr,
+	}
+	return sched.Submit(spec)
+}
+
+// Job submitted by a command which prints the job ID on the first line
+// of stdout and then blocks until the job has finished.
+type blockingJob struct {
+	id     string
+	cmd    *exec.Cmd
+	copied chan struct{}
+}
+
+// Starts the submission command and reads the job ID.
+// The first line of stdout is passed to parseID.
+// All of stdout is copied to the writer, which may be nil.
+func startBlocking(cmd *exec.Cmd, stdout io.Writer, parseID func(string) string) (*blockingJob, error) {
+	name := cmd.Args[0]
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if stdout == nil {
+		stdout = ioutil.Discard
 	}
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	fmt.Fprint(stdout, line)
+	id := parseID(strings.TrimSpace(line))
+	if err != nil || len(id) == 0 {
+		// Job was not submitted.
+		io.Copy(stdout, br)
+		if err := cmd.Wait(); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return nil, fmt.Errorf("%s: could not read job ID", name)
+	}
+	log.Println("job ID:", id)
+
+	job := &blockingJob{id: id, cmd: cmd, copied: make(chan struct{})}
+	go func() {
+		io.Copy(stdout, br)
+		close(job.copied)
+	}()
+	return job, nil
+}
+
+func (j *blockingJob) ID() string {
+	return j.id
+}
+
+// Waits for the submission command to exit.
+func (j *blockingJob) Wait() error {
+	// Stdout must be consumed before calling cmd.Wait().
+	<-j.copied
+	return j.cmd.Wait()
+}
 
-	cmd := exec.Command("qsub", args...)
-	// Re-route stdout and stderr.
-	cmd.Stdout = subout
-	cmd.Stderr = suberr
-	log.Printf("qsub arguments: %#v", args)
-	return cmd.Run(), nil
+// Kills the submission command if it has not already exited.
+func (j *blockingJob) kill() {
+	j.cmd.Process.Kill()
 }
@@ -0,0 +1,51 @@
+package dstrfn
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// TaskError describes the failure of one element on a worker.
+// It is saved in the error file of the element
+// and returned by the master in MapError.Tasks.
+type TaskError struct {
+	// Message of the error returned by the function.
+	Msg string
+	// Go type of the error, for example "*os.PathError".
+	Type string
+	// Host on which the worker ran.
+	Host string
+	// Identifier of the job assigned by the scheduler, empty if unknown.
+	JobID string
+	// Attempt on which the element failed, starting at one.
+	// Set by the master.
+	Attempt int
+	// Time taken by the worker before it failed.
+	Wall time.Duration
+}
+
+func (err *TaskError) Error() string {
+	return err.Msg
+}
+
+func newTaskError(err error, wall time.Duration) *TaskError {
+	host, _ := os.Hostname()
+	return &TaskError{
+		Msg:   err.Error(),
+		Type:  fmt.Sprintf("%T", err),
+		Host:  host,
+		JobID: jobID(),
+		Wall:  wall,
+	}
+}
+
+// Returns the ID of the current job from the environment of the scheduler.
+func jobID() string {
+	for _, name := range []string{"PBS_JOBID", "SLURM_JOB_ID"} {
+		if id := os.Getenv(name); len(id) > 0 {
+			return id
+		}
+	}
+	return ""
+}
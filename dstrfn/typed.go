@@ -0,0 +1,105 @@
+package dstrfn
+
+import (
+	"context"
+	"fmt"
+)
+
+// MapTask is a registered map task with concrete types.
+// It computes y[i] = f(x[i], p) for all i.
+type MapTask[X, P, Y any] struct {
+	name string
+}
+
+// NewMapTask registers a function as a map task and returns a handle to it.
+// The settings of the task are the same as for RegisterMap.
+// The task is chunked using the Chunk option.
+// Like RegisterMap, it must be called before flag.Parse() and ExecIfSlave().
+//
+// Tasks without a parameter can use struct{} for P.
+// If P is a pointer, Map can be given nil, which f then receives.
+func NewMapTask[X, P, Y any](name string, f func(X, P) (Y, error), opts ...Option) *MapTask[X, P, Y] {
+	registerMap(name, false, &typedTask[X, P, Y]{f, true}, opts...)
+	return &MapTask[X, P, Y]{name}
+}
+
+// Returns the name under which the task was registered.
+func (t *MapTask[X, P, Y]) Name() string {
+	return t.name
+}
+
+// Map computes f(x[i], p) for all i.
+// Errors are the same as for MapContext.
+func (t *MapTask[X, P, Y]) Map(ctx context.Context, x []X, p P) ([]Y, error) {
+	var y []Y
+	err := MapContext(ctx, t.name, &y, x, p, DefaultStdout, DefaultStderr, nil)
+	return y, err
+}
+
+// CallTask is a registered task with concrete types.
+// It computes y = f(x).
+type CallTask[X, Y any] struct {
+	name string
+}
+
+// NewTask registers a function as a task and returns a handle to it.
+// The settings of the task are the same as for Register.
+// Like Register, it must be called before flag.Parse() and ExecIfSlave().
+func NewTask[X, Y any](name string, f func(X) (Y, error), opts ...Option) *CallTask[X, Y] {
+	g := func(x X, _ struct{}) (Y, error) { return f(x) }
+	register(name, &typedTask[X, struct{}, Y]{g, false}, opts...)
+	return &CallTask[X, Y]{name}
+}
+
+// Returns the name under which the task was registered.
+func (t *CallTask[X, Y]) Name() string {
+	return t.name
+}
+
+// Call computes f(x).
+// Errors are the same as for CallContext.
+func (t *CallTask[X, Y]) Call(ctx context.Context, x X) (Y, error) {
+	var y Y
+	err := CallContext(ctx, t.name, &y, x, DefaultStdout, DefaultStderr, nil)
+	return y, err
+}
+
+// Implements ConfigTask for a function with concrete types.
+type typedTask[X, P, Y any] struct {
+	F func(X, P) (Y, error)
+	// Does the task have a parameter?
+	HasConfig bool
+}
+
+func (t *typedTask[X, P, Y]) NewInput() interface{} {
+	return new(X)
+}
+
+// Returns nil if the task does not have a parameter.
+func (t *typedTask[X, P, Y]) NewConfig() interface{} {
+	if !t.HasConfig {
+		return nil
+	}
+	return new(P)
+}
+
+func (t *typedTask[X, P, Y]) NewOutput() interface{} {
+	return new(Y)
+}
+
+// A nil config is given to f as the zero value of P,
+// for example a nil pointer.
+func (t *typedTask[X, P, Y]) Func(x, p interface{}) (interface{}, error) {
+	xt, ok := x.(X)
+	if !ok {
+		return nil, fmt.Errorf("input has type %T, expect %T", x, xt)
+	}
+	var pt P
+	if t.HasConfig && p != nil {
+		pt, ok = p.(P)
+		if !ok {
+			return nil, fmt.Errorf("config has type %T, expect %T", p, pt)
+		}
+	}
+	return t.F(xt, pt)
+}
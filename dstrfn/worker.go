@@ -6,7 +6,9 @@ import (
 	"log"
 	"os"
 	"path"
+	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/jvlmdr/go-file/fileutil"
 )
@@ -15,12 +17,30 @@ var (
 	workerTask   string
 	workerDir    string
 	workerMapLen int
+	workerIndex  string
+	// Is the task the reduce of a MapReduce?
+	workerShuffle bool
+	// Name of codec and compression of inputs and outputs.
+	workerEncoding string
+	workerCompress string
+	// Are the inputs and outputs of the map packed?
+	workerPack bool
+	// Number of the submission and number of elements of each job of a packed map.
+	workerSegment int
+	workerGroup   int
 )
 
 func init() {
 	flag.StringVar(&workerTask, "dstrfn.task", "", "Task to execute as slave. Empty to execute as master.")
 	flag.StringVar(&workerDir, "dstrfn.dir", "", "Location of temporary files.")
 	flag.IntVar(&workerMapLen, "dstrfn.map", 0, "The number of tasks in the map. Zero if not a map operation.")
+	flag.StringVar(&workerIndex, "dstrfn.index", "", "File in temporary directory which gives the element of each job. Empty if every element was submitted.")
+	flag.StringVar(&workerEncoding, "dstrfn.encoding", "json", "Codec of input and output files.")
+	flag.StringVar(&workerCompress, "dstrfn.compress", "", "Compression of input and output files. Empty for none.")
+	flag.BoolVar(&workerPack, "dstrfn.pack", false, "Read input from the packed input file and write output to a segment.")
+	flag.IntVar(&workerSegment, "dstrfn.segment", 0, "Number of the submission. Used to name the output segments of a packed map.")
+	flag.IntVar(&workerGroup, "dstrfn.group", 1, "Number of consecutive elements performed by each job of a packed map.")
+	flag.BoolVar(&workerShuffle, "dstrfn.shuffle", false, "Reduce the values of each key in the partition file given as input. Used for the reduce of a MapReduce.")
 }
 
 // If the process is a worker, this function never returns.
@@ -36,8 +56,12 @@ func ExecIfSlave() {
 }
 
 func worker() error {
+	sched, err := scheduler()
+	if err != nil {
+		return err
+	}
 	// Change current directory to that of submission.
-	wd, err := getenv("PBS_O_WORKDIR")
+	wd, err := sched.WorkDir()
 	if err != nil {
 		return err
 	}
@@ -46,57 +70,161 @@ func worker() error {
 	}
 
 	// Determine file locations.
+	ext, err := fileExt(workerEncoding, workerCompress)
+	if err != nil {
+		return err
+	}
+	if workerMapLen > 0 && workerPack {
+		job, elems, err := jobElems(sched)
+		if err != nil {
+			return err
+		}
+		return packedWorker(job, elems, ext)
+	}
+
 	var inFile, outFile, errFile string
 	if workerMapLen > 0 {
 		// If this is a map task, then use the array index.
-		var ind int
-		// Array index cannot be set for maps of 1 job.
-		// In this case the index is zero.
-		if workerMapLen > 1 {
-			var err error
-			ind, err = getenvInt("PBS_ARRAY_INDEX")
-			if err != nil {
-				return err
-			}
-			// Convert to zero-indexed.
-			ind--
+		_, elems, err := jobElems(sched)
+		if err != nil {
+			return err
 		}
-		inFile = fmt.Sprintf("in-%d.json", ind)
-		outFile = fmt.Sprintf("out-%d.json", ind)
+		ind := elems[0]
+		inFile = fmt.Sprintf("in-%d.%s", ind, ext)
+		outFile = fmt.Sprintf("out-%d.%s", ind, ext)
 		errFile = fmt.Sprintf("err-%d.json", ind)
 	} else {
-		inFile = "in.json"
-		outFile = "out.json"
+		inFile = "in." + ext
+		outFile = "out." + ext
 		errFile = "err.json"
 	}
 	inFile = path.Join(workerDir, inFile)
 	outFile = path.Join(workerDir, outFile)
 	errFile = path.Join(workerDir, errFile)
 	// Config file does not vary with index.
-	confFile := path.Join(workerDir, "conf.json")
+	confFile := path.Join(workerDir, "conf."+ext)
+
+	loadInput := func(x interface{}) error {
+		log.Println("load input:", inFile)
+		return loadFile(inFile, x)
+	}
+	saveOutput := func(y interface{}) error {
+		log.Println("save output:", outFile)
+		// The master may read the output before the job has finished.
+		return saveFileAtomic(outFile, y)
+	}
 
 	// Error can only be communicated once the task ID has been determined.
-	if err := doTask(inFile, confFile, outFile); err != nil {
+	start := time.Now()
+	if err := doTask(loadInput, confFile, saveOutput); err != nil {
 		// Attempt to save error.
-		if err := fileutil.SaveExt(errFile, err.Error()); err != nil {
+		// The master may read the error before the job has finished.
+		if err := saveFileAtomic(errFile, newTaskError(err, time.Since(start))); err != nil {
 			return fmt.Errorf("save error: %v", err)
 		}
 	}
 	return nil
 }
 
+// Returns the array index of the job and the elements of the map which it performs.
+// Each job performs -dstrfn.group consecutive elements of those submitted.
+func jobElems(sched Scheduler) (int, []int, error) {
+	group := max(workerGroup, 1)
+	var job int
+	// Array index cannot be set for maps of 1 job.
+	// In this case the index is zero.
+	if ceilDiv(workerMapLen, group) > 1 {
+		var err error
+		job, err = sched.ArrayIndex()
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	a, b := job*group, min((job+1)*group, workerMapLen)
+	if job < 0 || a >= b {
+		return 0, nil, fmt.Errorf("array index out of range: %d", job)
+	}
+	if len(workerIndex) > 0 {
+		// Only a subset of the elements was submitted.
+		var inds []int
+		if err := fileutil.LoadExt(path.Join(workerDir, workerIndex), &inds); err != nil {
+			return 0, nil, fmt.Errorf("load index: %v", err)
+		}
+		if b > len(inds) {
+			return 0, nil, fmt.Errorf("array index out of range: %d", job)
+		}
+		return job, inds[a:b], nil
+	}
+	elems := make([]int, b-a)
+	for i := range elems {
+		elems[i] = a + i
+	}
+	return job, elems, nil
+}
+
+// Performs each element of a job of a packed map.
+// The inputs are read from the packed input file
+// and the outputs are appended to one segment for the job.
+func packedWorker(job int, elems []int, ext string) error {
+	inFile := path.Join(workerDir, "in."+ext)
+	confFile := path.Join(workerDir, "conf."+ext)
+	name := fmt.Sprintf("seg-%d-%d", workerSegment, job)
+	segFile := path.Join(workerDir, name+"."+ext)
+	seg, err := createSeg(segFile, path.Join(workerDir, name+".index"))
+	if err != nil {
+		return fmt.Errorf("create segment: %v", err)
+	}
+	for _, i := range elems {
+		loadInput := func(x interface{}) error {
+			log.Printf("load input: %s element %d", inFile, i)
+			// Read only the location of this element from the index.
+			s, err := readIndex(path.Join(workerDir, "in.index"), i)
+			if err != nil {
+				return err
+			}
+			return loadSpan(inFile, s, x)
+		}
+		saveOutput := func(y interface{}) error {
+			log.Printf("save output: %s element %d", segFile, i)
+			return seg.Add(i, y)
+		}
+		start := time.Now()
+		if err := doTask(loadInput, confFile, saveOutput); err != nil {
+			errFile := path.Join(workerDir, fmt.Sprintf("err-%d.json", i))
+			if err := saveFileAtomic(errFile, newTaskError(err, time.Since(start))); err != nil {
+				return fmt.Errorf("save error: %v", err)
+			}
+		}
+	}
+	// The master merges the segment once its index exists.
+	if err := seg.Close(); err != nil {
+		return fmt.Errorf("save segment: %v", err)
+	}
+	return nil
+}
+
 // An error returned by this function will be communicated to the master.
 // Or at least we will try.
 // This can only be done once the task ID has been determined.
-func doTask(inFile, confFile, outFile string) error {
+func doTask(loadInput func(interface{}) error, confFile string, saveOutput func(interface{}) error) error {
 	// Look up task by name.
 	var task ConfigTask
 	if workerMapLen > 0 {
 		spec, there := mapTasks[workerTask]
+		if !there {
+			// Reduce tasks are executed as maps.
+			spec, there = reduceTasks[workerTask]
+		}
 		if !there {
 			return fmt.Errorf(`map task not found: "%s"`, workerTask)
 		}
 		task = spec.Task
+		if workerShuffle {
+			task = &partitionTask{unchunk(task)}
+		} else if workerPack {
+			// Each element of a packed map is performed separately.
+			task = unchunk(task)
+		}
 	} else {
 		spec, there := tasks[workerTask]
 		if !there {
@@ -107,28 +235,30 @@ func doTask(inFile, confFile, outFile string) error {
 
 	x := task.NewInput()
 	if x != nil {
-		log.Println("load input:", inFile)
-		if err := fileutil.LoadExt(inFile, x); err != nil {
+		if err := loadInput(x); err != nil {
 			return fmt.Errorf("load input: %v", err)
 		}
 		x = deref(x)
 	}
 	p := task.NewConfig()
-	if p != nil {
+	if _, err := os.Stat(confFile); p != nil && os.IsNotExist(err) {
+		// The master does not save a nil config.
+		log.Println("no config:", confFile)
+		p = nil
+	} else if p != nil {
 		log.Println("load config:", confFile)
-		if err := fileutil.LoadExt(confFile, p); err != nil {
+		if err := loadFile(confFile, p); err != nil {
 			return fmt.Errorf("load config: %v", err)
 		}
 		p = deref(p)
 	}
 	log.Println("call function")
-	y, err := task.Func(x, p)
+	y, err := callFunc(task, x, p)
 	if err != nil {
 		return err
 	}
 	if y != nil {
-		log.Println("save output:", outFile)
-		if err := fileutil.SaveExt(outFile, y); err != nil {
+		if err := saveOutput(y); err != nil {
 			return fmt.Errorf("save output: %v", err)
 		}
 	}
@@ -154,3 +284,25 @@ func getenvInt(name string) (int, error) {
 	}
 	return int(x), nil
 }
+
+// Calls the function of the task.
+// A panic is recovered and returned as an error
+// which contains the panic value and the stack of the goroutine.
+func callFunc(task ConfigTask, x, p interface{}) (y interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			err = panicError{r, buf[:runtime.Stack(buf, false)]}
+		}
+	}()
+	return task.Func(x, p)
+}
+
+type panicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (err panicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", err.Value, err.Stack)
+}